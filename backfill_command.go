@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RunBackfillCommand processes historical logs again by the selected rules and sends found events through the outputs,
+// offsets of the agent are not changed, so it can be run while the agent is working
+func RunBackfillCommand(options *Options, args []string) error {
+	flagSet := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	ruleNames := flagSet.String("rules", "", "comma separated names of rule files, all rules by default")
+	since := flagSet.String("since", "", "time of the first event, RFC3339 or duration, like 720h")
+	until := flagSet.String("until", "", "time of the last event, now by default")
+	rate := flagSet.Int("rate", 100, "max number of events sent per second, 0 means no limit")
+
+	paths, err := parseCommandArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+
+	if len(*since) < 1 {
+		return errors.New("backfill requires --since option")
+	}
+
+	backfill := &BackfillRange{
+		Until: time.Now(),
+		Rate:  *rate,
+	}
+
+	backfill.Since, err = ParseSinceTime(*since)
+	if err != nil {
+		return err
+	}
+
+	if len(*until) > 0 {
+		backfill.Until, err = ParseSinceTime(*until)
+		if err != nil {
+			return err
+		}
+	}
+
+	if backfill.Until.Before(backfill.Since) {
+		return errors.New(fmt.Sprintf("--until %s is before --since %s", backfill.Until.Format(time.RFC3339), backfill.Since.Format(time.RFC3339)))
+	}
+
+	config, err := LoadConfig(options)
+	if err != nil {
+		return errors.New("failed loading config files")
+	}
+
+	rules, err := backfillRules(config.Input.RuleConfigs, *ruleNames, paths)
+	if err != nil {
+		return err
+	}
+
+	// dead letters and sequence numbers of events are shared with the agent, offsets of the state are not touched
+	err = ConfigureStateDirs(options)
+	if err != nil {
+		return err
+	}
+
+	// requests failed to be sent are spooled apart from requests of the agent and replayed by the next backfill
+	SpoolNamespace = "backfill"
+	backfillLockFileName := filepath.Join(SpoolDir, ".backfill.lock")
+	backfillLock, err := LockFile(backfillLockFileName)
+	if err != nil {
+		return errors.New(fmt.Sprintf("another backfill is running with spool directory '%s': %s", SpoolDir, err))
+	}
+	defer backfillLock.Close()
+
+	// historical events are not notified by webhooks and don't need the fast path
+	config.Output.Webhooks = nil
+	config.Output.Batch.DisableFastPath = true
+	config.Output.Batch.idleTimeout = 2 * time.Second
+
+	systemState := &SystemState{
+		Input:     make(chan []*SecurityEventsContainer),
+		Ephemeral: true,
+	}
+
+	pipeline := NewPipeline(options, &config, systemState)
+	pipeline.Run()
+
+	emitLine(logLevel.important, "backfill of events from %s to %s is started", backfill.Since.Format(time.RFC3339), backfill.Until.Format(time.RFC3339))
+
+	filesCrawler := &FilesCrawler{
+		Rules:         rules,
+		SystemState:   systemState,
+		NextChannel:   pipeline.Input,
+		Overload:      pipeline.Overload,
+		ChunkEvents:   config.Input.ChunkEvents,
+		ChunkBytes:    int64(config.Input.ChunkSize) * 1024 * 1024,
+		MaxLineLength: config.Input.MaxLineSize * 1024,
+		Backfill:      backfill,
+	}
+	filesCrawler.Init()
+	filesCrawler.Run()
+
+	pipeline.Wait()
+
+	emitLine(logLevel.important, "backfill is finished, %d events are sent", backfill.Events)
+	return nil
+}
+
+// backfillRules selects file rules by names, paths of the rules are replaced by the specified paths
+func backfillRules(ruleConfigs []RuleConfig, ruleNames string, paths []string) ([]*RuleConfig, error) {
+	names := make(map[string]bool)
+	for _, name := range strings.Split(ruleNames, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) > 0 {
+			names[name] = false
+		}
+	}
+
+	for i, path := range paths {
+		absPath, err := filepath.Abs(NormalizeFileName(path))
+		if err != nil {
+			return nil, err
+		}
+		paths[i] = absPath
+	}
+
+	rules := make([]*RuleConfig, 0)
+	for _, config := range ruleConfigs {
+		ruleConfig := config
+		if ruleConfig.Source == "wineventlog" {
+			continue
+		}
+
+		if len(names) > 0 {
+			if _, found := names[ruleConfig.RuleFileName]; !found {
+				continue
+			}
+			names[ruleConfig.RuleFileName] = true
+		}
+
+		if len(paths) > 0 {
+			ruleConfig.Paths = paths
+		}
+
+		rules = append(rules, &ruleConfig)
+	}
+
+	for name, found := range names {
+		if !found {
+			return nil, errors.New(fmt.Sprintf("rule '%s' is not found or it's not a file rule", name))
+		}
+	}
+
+	if len(rules) < 1 {
+		return nil, errors.New("no file rules are selected for backfill")
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+const commandsUsage = `Commands:
+  state list                         show sources, offsets and lag in bytes
+  state reset <path|id>              process the source from the beginning
+  state rewind <path> --since <time> process the file from the first event since the time (RFC3339 or duration, like 2h)
+  state export [file]                write the state as json to the file or stdout
+  state import <file>                replace offsets by the state exported before
+  backfill --since <time> [--until <time>] [--rules <names>] [--rate <events per second>] [paths]
+                                     send events of historical logs again, tagged with backfill=true
+`
+
+// RunCommand runs a maintenance command instead of the agent and exits with the command status
+func RunCommand(options *Options, args []string) {
+	var err error
+
+	switch args[0] {
+	case "state":
+		err = RunStateCommand(options, args[1:])
+	case "backfill":
+		err = RunBackfillCommand(options, args[1:])
+	default:
+		err = fmt.Errorf("unknown command '%s'", args[0])
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n%s", err, commandsUsage)
+		os.Exit(exitStat.faulted)
+	}
+}
+
+// parseCommandArgs parses flags placed before and after positional arguments and returns positional ones
+func parseCommandArgs(flagSet *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0)
+
+	for {
+		err := flagSet.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+
+		if flagSet.NArg() < 1 {
+			return positional, nil
+		}
+
+		positional = append(positional, flagSet.Arg(0))
+		args = flagSet.Args()[1:]
+	}
+}
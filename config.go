@@ -16,6 +16,12 @@ import (
 type MainConfig struct {
 	Output OutputConfig `json:output yaml:output`
 	Input  InputConfig  `json:input yaml:input`
+
+	Overload OverloadConfig `json:"overload,omitempty" yaml:"overload,omitempty"`
+
+	// directories of the source state and of requests waiting to be sent again, -state-dir and -spool-dir options override them
+	StateDir string `json:"statedir,omitempty" yaml:"statedir,omitempty"`
+	SpoolDir string `json:"spooldir,omitempty" yaml:"spooldir,omitempty"`
 }
 
 type OutputConfig struct {
@@ -23,6 +29,47 @@ type OutputConfig struct {
 	ServerKey   string `json:serverkey yaml:serverkey`
 	Environment string `json:environment,omitempty yaml:environment,omitempty`
 	Proxy       string `json:proxy,omitempty yaml:proxy,omitempty`
+
+	// secrets can be read from a file (readable only by owner) or from output of a credential helper
+	AccessTokenFile string `json:"accesstoken_file,omitempty" yaml:"accesstoken_file,omitempty"`
+	AccessTokenCmd  string `json:"accesstoken_cmd,omitempty" yaml:"accesstoken_cmd,omitempty"`
+	ServerKeyFile   string `json:"serverkey_file,omitempty" yaml:"serverkey_file,omitempty"`
+	ServerKeyCmd    string `json:"serverkey_cmd,omitempty" yaml:"serverkey_cmd,omitempty"`
+
+	// collector url, overrides environment; failover urls are tried in order when the collector is not available
+	Url          string     `json:"url,omitempty" yaml:"url,omitempty"`
+	FailoverUrls []string   `json:"failoverurls,omitempty" yaml:"failoverurls,omitempty"`
+	Tls          *TlsConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
+	// header or body (default): the access token is sent in the Authorization header only, or also in the json body
+	AuthMode string `json:"authmode,omitempty" yaml:"authmode,omitempty"`
+	// key of HMAC-SHA256 request signature, the access token by default
+	SigningKey string `json:"signingkey,omitempty" yaml:"signingkey,omitempty"`
+	// number of requests sent to the collector at the same time and number of batches waiting to be sent
+	MaxInFlight int `json:"maxinflight,omitempty" yaml:"maxinflight,omitempty"`
+	SendBuffer  int `json:"sendbuffer,omitempty" yaml:"sendbuffer,omitempty"`
+	// limits of batches sent to the collector
+	Batch BatchConfig `json:"batch,omitempty" yaml:"batch,omitempty"`
+
+	File          *FileOutputConfig          `json:"file,omitempty" yaml:"file,omitempty"`
+	Syslog        *SyslogOutputConfig        `json:"syslog,omitempty" yaml:"syslog,omitempty"`
+	Elasticsearch *ElasticsearchOutputConfig `json:"elasticsearch,omitempty" yaml:"elasticsearch,omitempty"`
+	Splunk        *SplunkOutputConfig        `json:"splunk,omitempty" yaml:"splunk,omitempty"`
+	Webhooks      []WebhookOutputConfig      `json:"webhooks,omitempty" yaml:"webhooks,omitempty"`
+}
+
+type BatchConfig struct {
+	MaxItems        int    `json:"maxitems,omitempty" yaml:"maxitems,omitempty"`
+	MaxEvents       int    `json:"maxevents,omitempty" yaml:"maxevents,omitempty"`
+	MaxBytes        int    `json:"maxbytes,omitempty" yaml:"maxbytes,omitempty"`
+	IdleTimeout     string `json:"idletimeout,omitempty" yaml:"idletimeout,omitempty"`
+	CriticalTimeout string `json:"criticaltimeout,omitempty" yaml:"criticaltimeout,omitempty"`
+	// critical events are sent right away on own connection with a short request timeout
+	DisableFastPath bool   `json:"disablefastpath,omitempty" yaml:"disablefastpath,omitempty"`
+	FastPathTimeout string `json:"fastpathtimeout,omitempty" yaml:"fastpathtimeout,omitempty"`
+
+	idleTimeout     time.Duration `json:"-" yaml:"-"`
+	criticalTimeout time.Duration `json:"-" yaml:"-"`
+	fastPathTimeout time.Duration `json:"-" yaml:"-"`
 }
 
 type InputConfig struct {
@@ -31,6 +78,12 @@ type InputConfig struct {
 	NetworkInterface string       `json:networkinterface yaml:networkinterface`
 	TrackDnsTraffic  bool         `json:trackdnstraffic yaml:trackdnstraffic`
 	RuleConfigs      []RuleConfig `json:- yaml:-`
+
+	// events of a file are sent in chunks of events or megabytes read, offset of the file is saved after every chunk
+	ChunkEvents int `json:"chunkevents,omitempty" yaml:"chunkevents,omitempty"`
+	ChunkSize   int `json:"chunksize,omitempty" yaml:"chunksize,omitempty"`
+	// only the beginning of longer lines is processed, kilobytes
+	MaxLineSize int `json:"maxlinesize,omitempty" yaml:"maxlinesize,omitempty"`
 }
 
 type RuleConfig struct {
@@ -41,9 +94,19 @@ type RuleConfig struct {
 	ExcludeFilesRegex string                `json:excludefilesregex yaml:excludefilesregex`
 	Events            []SecurityEventConfig `json:events yaml:events`
 	EventTimeFormat   string                `json:eventtimeformat yaml:eventtimeformat`
-	deadtime          time.Duration         `json:- yaml:-`
-
-	CompiledExcludeFilesRegex *regexp.Regexp `json:- yaml:-`
+	// where a newly discovered file is read from: beginning, end or since:<duration>
+	StartPosition string `json:"startposition,omitempty" yaml:"startposition,omitempty"`
+	// regexes of lines processed by the rule, a line should match one of included and none of excluded regexes
+	IncludeLines  []string      `json:"includelines,omitempty" yaml:"includelines,omitempty"`
+	ExcludeLines  []string      `json:"excludelines,omitempty" yaml:"excludelines,omitempty"`
+	deadtime      time.Duration `json:- yaml:-`
+	startPosition string
+	startSince    time.Duration
+
+	CompiledExcludeFilesRegex *regexp.Regexp   `json:- yaml:-`
+	CompiledIncludeLines      []*regexp.Regexp `json:- yaml:-`
+	CompiledExcludeLines      []*regexp.Regexp `json:- yaml:-`
+	Prefilter                 *LinePrefilter   `json:- yaml:-`
 
 	RuleFileName string `json:- yaml:-`
 }
@@ -59,6 +122,9 @@ type SecurityEventConfig struct {
 	ExcludeCompiledRegex map[string]*regexp.Regexp `json:- yaml:-`
 	CompiledRegex        *regexp.Regexp            `json:- yaml:-`
 	Critical             bool                      `json:critical yaml:critical`
+	// fields of fixed layout lines are split by delimiters of the dissect pattern instead of the regex
+	Dissect         string   `json:"dissect,omitempty" yaml:"dissect,omitempty"`
+	CompiledDissect *Dissect `json:"-" yaml:"-"`
 }
 
 func DiscoverYamlConfigs(directory string) (files []string, err error) {
@@ -92,6 +158,53 @@ func LoadConfig(options *Options) (config MainConfig, err error) {
 		return
 	}
 
+	err = ResolveSecrets(&config.Output)
+	if err != nil {
+		emitLine(logLevel.critical, "Failed loading secrets of main config file '%s': %s", mainConfig, err)
+		return
+	}
+
+	// normalize output
+	if config.Output.MaxInFlight <= 0 {
+		config.Output.MaxInFlight = 2
+	}
+
+	if config.Output.SendBuffer <= 0 {
+		config.Output.SendBuffer = 10
+	}
+
+	// normalize input
+	if config.Input.ChunkEvents <= 0 {
+		config.Input.ChunkEvents = 1000
+	}
+
+	if config.Input.ChunkSize <= 0 {
+		config.Input.ChunkSize = 4
+	}
+
+	if config.Input.MaxLineSize <= 0 {
+		config.Input.MaxLineSize = 1024
+	}
+
+	err = config.Overload.Normalize()
+	if err != nil {
+		emitLine(logLevel.critical, "Failed parsing overload settings in main config file '%s': %s", mainConfig, err)
+		return
+	}
+
+	err = config.Output.Batch.Normalize(options)
+	if err != nil {
+		emitLine(logLevel.critical, "Failed parsing batch settings in main config file '%s': %s", mainConfig, err)
+		return
+	}
+
+	patternsDir := path.Join(directory, "patterns.d")
+	patterns, err := LoadPatterns(patternsDir)
+	if err != nil {
+		emitLine(logLevel.critical, "Failed loading patterns in directory '%s': %s", patternsDir, err)
+		return
+	}
+
 	rulesDir := path.Join(directory, "rules.d")
 
 	ruleFiles, err := DiscoverYamlConfigs(rulesDir)
@@ -133,6 +246,11 @@ func LoadConfig(options *Options) (config MainConfig, err error) {
 				continue
 			}
 
+			if err := rule.ParseStartPosition(); err != nil {
+				emit(logLevel.important, "Failed parsing startposition in file '%s': %s.\n", ruleFile, err)
+				continue
+			}
+
 			if len(rule.ExcludeFilesRegex) > 0 {
 				excludeFilesRegex, err := regexp.Compile(rule.ExcludeFilesRegex)
 				if err != nil {
@@ -142,16 +260,51 @@ func LoadConfig(options *Options) (config MainConfig, err error) {
 				rule.CompiledExcludeFilesRegex = excludeFilesRegex
 			}
 
+			includeLines, err := CompileRegexes(rule.IncludeLines, patterns)
+			if err != nil {
+				emit(logLevel.important, "Failed parsing includelines in config file '%s'. Error: %s\n", ruleFile, err)
+				continue
+			}
+
+			excludeLines, err := CompileRegexes(rule.ExcludeLines, patterns)
+			if err != nil {
+				emit(logLevel.important, "Failed parsing excludelines in config file '%s'. Error: %s\n", ruleFile, err)
+				continue
+			}
+
+			rule.CompiledIncludeLines = includeLines
+			rule.CompiledExcludeLines = excludeLines
+
 			events := make([]SecurityEventConfig, 0)
 			for _, event := range rule.Events {
-				regex := event.Regex
-				compiledRegex, err := regexp.Compile(regex)
-				if err != nil {
-					emit(logLevel.important, "Failed parsing regex '%s' in config file '%s'. Error: %s\n", regex, ruleFile, err)
-					continue
-				}
+				if len(event.Dissect) > 0 {
+					if len(event.Regex) > 0 || rule.Source == "wineventlog" {
+						emit(logLevel.important, "Failed parsing dissect '%s' in config file '%s'. Error: dissect can't be used with regex or for wineventlog\n", event.Dissect, ruleFile)
+						continue
+					}
+
+					compiledDissect, err := CompileDissect(event.Dissect)
+					if err != nil {
+						emit(logLevel.important, "Failed parsing dissect '%s' in config file '%s'. Error: %s\n", event.Dissect, ruleFile, err)
+						continue
+					}
+					event.CompiledDissect = compiledDissect
+				} else {
+					regex, err := ExpandPatterns(event.Regex, patterns)
+					if err != nil {
+						emit(logLevel.important, "Failed expanding patterns of regex '%s' in config file '%s'. Error: %s\n", event.Regex, ruleFile, err)
+						continue
+					}
+					event.Regex = regex
+
+					compiledRegex, err := regexp.Compile(regex)
+					if err != nil {
+						emit(logLevel.important, "Failed parsing regex '%s' in config file '%s'. Error: %s\n", regex, ruleFile, err)
+						continue
+					}
 
-				event.CompiledRegex = compiledRegex
+					event.CompiledRegex = compiledRegex
+				}
 
 				if config.Input.AllRules == false {
 					if Contains(config.Input.Rules, rule.RuleFileName) == false {
@@ -163,7 +316,13 @@ func LoadConfig(options *Options) (config MainConfig, err error) {
 				if event.Exclude != nil && len(event.Exclude) > 0 {
 					event.ExcludeCompiledRegex = make(map[string]*regexp.Regexp)
 					for key, value := range event.Exclude {
-						excludeCompiledRegex, err := regexp.Compile(value)
+						excludeRegex, err := ExpandPatterns(value, patterns)
+						if err != nil {
+							emit(logLevel.important, "Failed expanding patterns of exclude regex: '%s' in config file '%s'. Error: %s\n", value, ruleFile, err)
+							continue
+						}
+
+						excludeCompiledRegex, err := regexp.Compile(excludeRegex)
 						if err != nil {
 							emit(logLevel.important, "Failed parsing exclude regex: '%s' in config file '%s'. Error: %s\n", value, ruleFile, err)
 							continue
@@ -176,6 +335,7 @@ func LoadConfig(options *Options) (config MainConfig, err error) {
 			}
 
 			rule.Events = events
+			rule.Prefilter = NewLinePrefilter(rule.Events)
 
 			if len(rule.Events) > 0 {
 				rules = append(rules, rule)
@@ -206,7 +366,7 @@ func LoadYamlFile(path string, out interface{}) error {
 	buffer := make([]byte, fi.Size())
 	_, err = ymlFile.Read(buffer)
 
-	buffer = []byte(os.ExpandEnv(string(buffer)))
+	buffer = ExpandEnvReferences(buffer)
 
 	err = yaml.Unmarshal(buffer, out)
 	if err != nil {
@@ -215,3 +375,166 @@ func LoadYamlFile(path string, out interface{}) error {
 
 	return nil
 }
+
+// RuleNamesBySid returns names of the rules which produce events with the given sid
+func RuleNamesBySid(rules []RuleConfig) map[uint]string {
+	ruleNamesBySid := make(map[uint]string)
+	for _, rule := range rules {
+		for _, event := range rule.Events {
+			ruleNames := ruleNamesBySid[event.Sid]
+			if len(ruleNames) < 1 {
+				ruleNamesBySid[event.Sid] = rule.RuleFileName
+			} else if !Contains(strings.Split(ruleNames, ","), rule.RuleFileName) {
+				ruleNamesBySid[event.Sid] = ruleNames + "," + rule.RuleFileName
+			}
+		}
+	}
+	return ruleNamesBySid
+}
+
+// Normalize sets default limits and parses timeouts, the idle timeout is taken from -timeout option by default
+func (batch *BatchConfig) Normalize(options *Options) error {
+	var err error
+
+	if batch.MaxItems <= 0 {
+		batch.MaxItems = 10
+	}
+
+	if batch.MaxEvents <= 0 {
+		batch.MaxEvents = 1000
+	}
+
+	if batch.MaxBytes <= 0 {
+		batch.MaxBytes = 1024 * 1024
+	}
+
+	batch.idleTimeout = options.IdleTimeout
+	if len(batch.IdleTimeout) > 0 {
+		batch.idleTimeout, err = time.ParseDuration(batch.IdleTimeout)
+		if err != nil {
+			return err
+		}
+	}
+
+	if batch.idleTimeout <= 0 {
+		batch.idleTimeout = 60 * time.Second
+	}
+
+	batch.criticalTimeout = 20 * time.Second
+	if len(batch.CriticalTimeout) > 0 {
+		batch.criticalTimeout, err = time.ParseDuration(batch.CriticalTimeout)
+		if err != nil {
+			return err
+		}
+	}
+
+	batch.fastPathTimeout = 5 * time.Second
+	if len(batch.FastPathTimeout) > 0 {
+		batch.fastPathTimeout, err = time.ParseDuration(batch.FastPathTimeout)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Match returns predefined fields of the event with fields extracted from the line by the regex or dissect,
+// nil is returned when the line does not match
+func (event *SecurityEventConfig) Match(line []byte) map[string]string {
+	var matches [][][]byte
+
+	if event.CompiledDissect != nil {
+		if !event.CompiledDissect.Match(line) {
+			return nil
+		}
+	} else {
+		matches = event.CompiledRegex.FindAllSubmatch(line, -1)
+		if len(matches) < 1 {
+			return nil
+		}
+	}
+
+	resultMap := make(map[string]string)
+
+	// fill result map with predefined fields
+	for key, value := range event.Fields {
+		resultMap[key] = value
+	}
+
+	if event.CompiledDissect != nil {
+		event.CompiledDissect.Fill(line, &resultMap)
+	} else {
+		RegexFillByteSubmatches(matches, event.CompiledRegex, &resultMap)
+	}
+
+	return resultMap
+}
+
+// AcceptLine checks the line by include and exclude filters of the rule
+func (rule *RuleConfig) AcceptLine(line []byte) bool {
+	if len(rule.CompiledIncludeLines) > 0 {
+		included := false
+		for _, regex := range rule.CompiledIncludeLines {
+			if regex.Match(line) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	for _, regex := range rule.CompiledExcludeLines {
+		if regex.Match(line) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseStartPosition validates the start position of newly discovered files,
+// events of the default period are read when it's not specified
+func (rule *RuleConfig) ParseStartPosition() error {
+	position := strings.ToLower(strings.TrimSpace(rule.StartPosition))
+
+	switch {
+	case position == "":
+		rule.startPosition = "since"
+	case position == "beginning" || position == "end":
+		rule.startPosition = position
+	case strings.HasPrefix(position, "since:"):
+		since, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(position, "since:")))
+		if err != nil {
+			return err
+		}
+		if since <= 0 {
+			return errors.New(fmt.Sprintf("duration of '%s' should be positive", rule.StartPosition))
+		}
+		rule.startPosition = "since"
+		rule.startSince = since
+	default:
+		return errors.New(fmt.Sprintf("unknown start position '%s', expected beginning, end or since:<duration>", rule.StartPosition))
+	}
+
+	return nil
+}
+
+// ParseEventTime parses time extracted from a log line by eventTime regex group
+func (rule *RuleConfig) ParseEventTime(eventTimeStr string) (time.Time, error) {
+	if len(rule.EventTimeFormat) < 1 {
+		eventTime, err := time.ParseInLocation(eventTimeStr, eventTimeStr, time.Local)
+		if err != nil {
+			return eventTime, errors.New(fmt.Sprintf("Failed parsing '%s'. Error: %s", eventTimeStr, err))
+		}
+		return eventTime, nil
+	}
+
+	eventTime, err := ExYearParseDate(rule.EventTimeFormat, eventTimeStr, time.Local)
+	if err != nil {
+		return eventTime, errors.New(fmt.Sprintf("Failed parsing '%s' to format '%s'. Error: %s", eventTimeStr, rule.EventTimeFormat, err))
+	}
+	return eventTime, nil
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"os"
@@ -13,13 +12,32 @@ import (
 )
 
 type FilesCrawler struct {
-	Rules                 []*RuleConfig
-	SystemState           *SystemState
-	NextChannel           chan *SecurityEventsContainer
+	Rules       []*RuleConfig
+	SystemState *SystemState
+	NextChannel chan *SecurityEventsContainer
+	Overload    *OverloadGuard
+	// events are sent in containers of at most chunk events or bytes read from the file
+	ChunkEvents int
+	ChunkBytes  int64
+	// only the beginning of longer lines is processed
+	MaxLineLength int
+	// files are crawled once with bounds of event time when backfill is set
+	Backfill              *BackfillRange
 	_firstRun             bool
 	_inited               bool
 	_crawlPeriod          time.Duration
 	_defaultPeriodToParse time.Duration
+	_throttle             *time.Ticker
+	_candidates           []bool
+}
+
+// BackfillRange limits events processed again by backfill
+type BackfillRange struct {
+	Since time.Time
+	Until time.Time
+	// max number of events per second, 0 means no limit
+	Rate   int
+	Events int64
 }
 
 func (crawler *FilesCrawler) Init() {
@@ -30,6 +48,23 @@ func (crawler *FilesCrawler) Init() {
 
 	crawler._crawlPeriod = 60 * time.Second
 	crawler._defaultPeriodToParse = time.Hour * 24 * 30
+
+	if crawler.ChunkEvents <= 0 {
+		crawler.ChunkEvents = 1000
+	}
+
+	if crawler.ChunkBytes <= 0 {
+		crawler.ChunkBytes = 4 * 1024 * 1024
+	}
+
+	if crawler.MaxLineLength <= 0 {
+		crawler.MaxLineLength = 1024 * 1024
+	}
+
+	if crawler.Backfill != nil && crawler.Backfill.Rate > 0 {
+		crawler._throttle = time.NewTicker(time.Second / time.Duration(crawler.Backfill.Rate))
+	}
+
 	crawler._inited = true
 }
 
@@ -40,16 +75,29 @@ func (crawler *FilesCrawler) Run() {
 
 		crawler._firstRun = true
 		for {
-			crawler._RunOnce()
+			paused := crawler._RunOnce()
+
+			if !paused && crawler.Backfill != nil {
+				return
+			}
 
 			crawler._firstRun = false
+
+			if paused {
+				// files of paused rules are read again as soon as the agent recovers from overload
+				crawler.Overload.WaitRecovered()
+				continue
+			}
+
 			time.Sleep(crawler._crawlPeriod)
 		}
 
 	}
 }
 
-func (crawler *FilesCrawler) _RunOnce() {
+// _RunOnce reads new lines of the files and returns true when some files are not read to the end because of overload
+func (crawler *FilesCrawler) _RunOnce() bool {
+	pausedFiles := false
 	pathOnRulesMap := crawler._GetFilesListMap()
 	// debugJson(pathOnRulesMap)
 
@@ -80,7 +128,12 @@ func (crawler *FilesCrawler) _RunOnce() {
 
 		fileModified := fileInfo.ModTime()
 
-		if time.Now().Sub(fileModified) > maxFileDeadTime {
+		if crawler.Backfill != nil {
+			// backfill is bounded by event time, the file modified before the range does not contain its events
+			if fileModified.Before(crawler.Backfill.Since) {
+				continue
+			}
+		} else if time.Now().Sub(fileModified) > maxFileDeadTime {
 			// the file is so old for parsing
 			// emitLine(logLevel.verbose, "file '%s' so old. Last modified time: %s, max file deadtime: %s", path, fileModified.String(), maxFileDeadTime.String())
 			continue
@@ -93,6 +146,14 @@ func (crawler *FilesCrawler) _RunOnce() {
 		if sourceState.Offset > 0 {
 			position = sourceState.Offset
 			linePosition = sourceState.Line
+		} else if crawler._firstRun && crawler.Backfill == nil && sourceState.LastUpdatedTimeUtcNumber == 0 {
+			// the file is seen for the first time, files appearing later, like rotated ones, are read from the beginning
+			position, linePosition = crawler._StartPosition(path, fileInfo.Size(), rules)
+			sourceState.Offset = position
+			sourceState.Line = linePosition
+
+			// the position is committed, so the restarted agent doesn't search it again and skip lines written meanwhile
+			crawler.NextChannel <- &SecurityEventsContainer{SourceId: sourceState.SourceId, Source: path, Offset: position, Line: linePosition}
 		}
 
 		if crawler._firstRun {
@@ -147,62 +208,116 @@ func (crawler *FilesCrawler) _RunOnce() {
 			file.Seek(position, io.SeekStart)
 		}
 
-		reader := bufio.NewReaderSize(decodingReader, 10*1024)
+		// offset of the current line is known only when the file is read without decoding,
+		// otherwise chunks are sent with the offset where reading started, so a restart doesn't skip lines
+		exactOffsets := decodingReader == io.Reader(file)
+		startPosition := position
+		startLinePosition := linePosition
+
+		// the reader starts after the byte order mark skipped by the decoding reader
+		readerPosition, _ := file.Seek(0, io.SeekCurrent)
+		chunkPosition := readerPosition
+
+		reader := NewLineReader(decodingReader, readerPosition, 64*1024, crawler.MaxLineLength)
 		eventsContainer := &SecurityEventsContainer{}
 		eventsContainer.SourceId = sourceState.SourceId
 		eventsContainer.Source = path
 
 		src := path
 
+		paused := false
+
 		for {
-			segment, err := reader.ReadBytes('\n')
+			// send collected events and stop reading while the pipeline is overloaded, the offset of the last read line is kept
+			if crawler.Overload.Throttled(rules) {
+				if len(eventsContainer.SecurityEvents) > 0 {
+					eventsContainer = crawler._SendChunk(eventsContainer, exactOffsets, startPosition, startLinePosition)
+				}
 
-			line := string(segment)
-			if len(line) > 0 {
-				// remove \r\n from the end of the string
-				if line[len(line)-1] == '\n' {
-					drop := 1
-					if len(line) > 1 && line[len(line)-2] == '\r' {
-						drop = 2
-					}
-					line = line[:len(line)-drop]
-				} else if err == io.EOF {
-					// this is the last line before file END, need to decide - process or not to process
-					// if file is still modifiying, break the last line
-					if time.Now().Sub(fileModified) < time.Second*60 {
-						// debug(time.Now().Sub(fileModified).String())
-						break
-					}
+				if crawler.Overload.Config.Policy == "pause" {
+					// the file is read again by the next crawl after recovery
+					paused = true
+					break
 				}
+				crawler.Overload.WaitRecovered()
 			}
+
+			if crawler.Overload.Exhausted() {
+				if len(eventsContainer.SecurityEvents) > 0 {
+					eventsContainer = crawler._SendChunk(eventsContainer, exactOffsets, startPosition, startLinePosition)
+				}
+				crawler.Overload.Wait()
+			}
+
+			line, err := reader.ReadLine()
+			if err != nil && err != io.EOF {
+				emitLine(logLevel.important, "unexpected error during reading the file '%s'. error: %s", path, err)
+				break
+			}
+
+			if reader.Truncated() {
+				emitLine(logLevel.verbose, "line %d of file '%s' is longer than %d bytes, only its beginning is processed.", linePosition, path, crawler.MaxLineLength)
+			}
+
+			if err == io.EOF {
+				if len(line) < 1 {
+					break
+				}
+
+				// this is the last line before file END, need to decide - process or not to process
+				// if file is still modifiying, break the last line
+				if time.Now().Sub(fileModified) < time.Second*60 {
+					break
+				}
+			}
+
 			if linePosition > 0 {
 				linePosition++
 			}
 
-			position, _ := file.Seek(0, io.SeekCurrent)
-
 			sourceState.Line = linePosition
-			sourceState.Offset = position
-			eventsContainer.Offset = sourceState.Offset
 			eventsContainer.Line = sourceState.Line
+			if exactOffsets {
+				sourceState.Offset = reader.Offset()
+				eventsContainer.Offset = sourceState.Offset
+			}
 
 			if len(line) > 0 {
+				eventsBefore := len(eventsContainer.SecurityEvents)
+
 				// process line by correspondent rules
 				for _, rule := range rules {
-					crawler.ParseLine(&src, linePosition, &line, rule, eventsContainer)
+					crawler.ParseLine(&src, linePosition, line, rule, eventsContainer)
 				}
-			}
 
-			if err != nil {
-				if err == io.EOF {
-					break
-				} else if err == bufio.ErrBufferFull {
-					continue
-				} else {
-					emitLine(logLevel.important, "unexpected error during reading the file '%s'. error: %s", path, err)
-					break
+				if crawler._throttle != nil {
+					eventsContainer = crawler._ThrottleBackfill(eventsContainer, len(eventsContainer.SecurityEvents)-eventsBefore)
 				}
 			}
+
+			// send the chunk and checkpoint the offset, so memory doesn't grow with size of the file
+			if len(eventsContainer.SecurityEvents) >= crawler.ChunkEvents || reader.Offset()-chunkPosition >= crawler.ChunkBytes {
+				eventsContainer = crawler._SendChunk(eventsContainer, exactOffsets, startPosition, startLinePosition)
+				chunkPosition = reader.Offset()
+			}
+
+			if err == io.EOF {
+				break
+			}
+		}
+
+		if paused {
+			pausedFiles = true
+		}
+
+		if paused && !exactOffsets {
+			// decoded lines are not mapped to offsets of the file, so the file is read again from the start of reading
+			eventsContainer.Offset = startPosition
+			eventsContainer.Line = startLinePosition
+		} else if !exactOffsets {
+			// decoded lines are not mapped to offsets of the file, so the file is read to the position of the last read
+			sourceState.Offset, _ = file.Seek(0, io.SeekCurrent)
+			eventsContainer.Offset = sourceState.Offset
 		}
 
 		eventsContainer.CleanSecurityEventsFromDublicates()
@@ -211,50 +326,129 @@ func (crawler *FilesCrawler) _RunOnce() {
 		// debug("finished processing file %s. security events: %d. rules: %d", path, len(eventsContainer.SecurityEvents), len(rules))
 
 	} // # end for path, rules := range pathOnRulesMap
+
+	return pausedFiles
 }
 
-func (crawler *FilesCrawler) ParseLine(source *string, linePosition int64, text *string, rule *RuleConfig, eventsContainer *SecurityEventsContainer) {
+// _StartPosition returns offset and line where a newly discovered file is read from,
+// the earliest position among the rules is used
+func (crawler *FilesCrawler) _StartPosition(path string, fileSize int64, rules []*RuleConfig) (int64, int64) {
+	position := fileSize
+	var linePosition int64 = 0
+
+	for _, rule := range rules {
+		switch rule.startPosition {
+		case "beginning":
+			return 0, 1
+		case "since":
+			period := rule.startSince
+			if period <= 0 {
+				period = crawler._defaultPeriodToParse
+			}
 
-	for _, eventFilter := range rule.Events {
+			offset, line, err := FindOffsetSince(path, time.Now().Add(-period), []*RuleConfig{rule})
+			if err != nil {
+				emitLine(logLevel.important, "failed searching events since %s in file '%s', it's read from the beginning. error: %s", period, path, err)
+				return 0, 1
+			}
 
-		regex := eventFilter.CompiledRegex
-		securityId := eventFilter.Sid
+			if offset <= position {
+				position = offset
+				linePosition = line
+			}
+		}
+	}
 
-		matches := regex.FindStringSubmatch(*text)
+	if linePosition < 1 {
+		line, err := CountLines(path, position)
+		if err != nil {
+			emitLine(logLevel.important, "failed counting lines of file '%s'. error: %s", path, err)
+		}
+		linePosition = line
+	}
 
-		if matches != nil && len(matches) > 0 {
+	emitLine(logLevel.important, "file '%s' is discovered, it's read from position %d (line:%d).", path, position, linePosition)
 
-			resultMap := make(map[string]string)
+	return position, linePosition
+}
 
-			// fill result map with predefined fields
-			if len(eventFilter.Fields) > 0 {
-				for key, value := range eventFilter.Fields {
-					resultMap[key] = value
-				}
-			}
+// _SendContainer sends events collected from the file and returns an empty container continuing from the same offset
+func (crawler *FilesCrawler) _SendContainer(eventsContainer *SecurityEventsContainer) *SecurityEventsContainer {
+	eventsContainer.CleanSecurityEventsFromDublicates()
+	crawler.NextChannel <- eventsContainer
 
-			RegexFindAllSubmatches(text, regex, &resultMap)
+	return &SecurityEventsContainer{
+		SourceId: eventsContainer.SourceId,
+		Source:   eventsContainer.Source,
+		Offset:   eventsContainer.Offset,
+		Line:     eventsContainer.Line,
+	}
+}
+
+// _SendChunk sends events read from the middle of the file, the offset is moved back to the start of reading
+// when offsets of lines are not known
+func (crawler *FilesCrawler) _SendChunk(eventsContainer *SecurityEventsContainer, exactOffsets bool, startPosition int64, startLinePosition int64) *SecurityEventsContainer {
+	if !exactOffsets {
+		eventsContainer.Offset = startPosition
+		eventsContainer.Line = startLinePosition
+	}
+
+	return crawler._SendContainer(eventsContainer)
+}
+
+// _ThrottleBackfill waits a tick for every new event and sends events collected during a second
+func (crawler *FilesCrawler) _ThrottleBackfill(eventsContainer *SecurityEventsContainer, newEvents int) *SecurityEventsContainer {
+	for i := 0; i < newEvents; i++ {
+		<-crawler._throttle.C
+	}
+
+	if len(eventsContainer.SecurityEvents) >= crawler.Backfill.Rate {
+		return crawler._SendContainer(eventsContainer)
+	}
+
+	return eventsContainer
+}
+
+func (crawler *FilesCrawler) ParseLine(source *string, linePosition int64, line []byte, rule *RuleConfig, eventsContainer *SecurityEventsContainer) {
+
+	if !rule.AcceptLine(line) {
+		return
+	}
+
+	// regexes are run only for events which required literals are found in the line
+	if rule.Prefilter != nil {
+		crawler._candidates = rule.Prefilter.Candidates(line, crawler._candidates)
+	}
+
+	for i, eventFilter := range rule.Events {
+
+		if rule.Prefilter != nil && !crawler._candidates[i] {
+			continue
+		}
+
+		securityId := eventFilter.Sid
+
+		// strings are allocated only for fields of matched lines
+		resultMap := eventFilter.Match(line)
+
+		if resultMap != nil {
 
 			// emitJson(logLevel.verbose, resultMap)
 
 			// parse datetime
 			eventTimeStr := resultMap["eventTime"]
 
-			var eventTime time.Time
-			var err error
-			if len(rule.EventTimeFormat) < 1 {
-				eventTime, err = time.ParseInLocation(eventTimeStr, eventTimeStr, time.Local)
+			eventTime, err := rule.ParseEventTime(eventTimeStr)
+			if err != nil {
+				emit(logLevel.important, "FileReader: %s\n", err)
+				continue
+			}
 
-				if err != nil {
-					emit(logLevel.important, "FileReader: Failed parsing '%s'. Error: %s\n", eventTimeStr, err)
-					continue
-				}
-			} else {
-				eventTime, err = ExYearParseDate(rule.EventTimeFormat, eventTimeStr, time.Local)
-				if err != nil {
-					emit(logLevel.important, "FileReader: Failed parsing '%s' to format '%s'. Error: %s\n", eventTimeStr, rule.EventTimeFormat, err)
+			if crawler.Backfill != nil {
+				if eventTime.Before(crawler.Backfill.Since) || eventTime.After(crawler.Backfill.Until) {
 					continue
 				}
+				resultMap["backfill"] = "true"
 			}
 
 			eventTimeNumber := DateToCustomLong(eventTime)
@@ -297,7 +491,13 @@ func (crawler *FilesCrawler) ParseLine(source *string, linePosition int64, text
 					Source:             &eventSource,
 				}
 
-				eventsContainer.SecurityEvents = append(eventsContainer.SecurityEvents, securityEvent)
+				if crawler.Overload.Admit(rule.RuleFileName, securityEvent, eventsContainer) {
+					eventsContainer.SecurityEvents = append(eventsContainer.SecurityEvents, securityEvent)
+
+					if crawler.Backfill != nil {
+						crawler.Backfill.Events++
+					}
+				}
 				// debugJson(eventsContainer)
 			}
 		}
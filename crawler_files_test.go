@@ -0,0 +1,348 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+func newTestCrawler(rules []*RuleConfig) *FilesCrawler {
+	overload := &OverloadGuard{Config: &OverloadConfig{}}
+	overload.Config.Normalize()
+	overload.Init()
+
+	crawler := &FilesCrawler{
+		Rules:       rules,
+		SystemState: &SystemState{Ephemeral: true},
+		Overload:    overload,
+	}
+	crawler.Init()
+
+	return crawler
+}
+
+// crawlTestFiles reads files of the crawler once and returns containers sent by it, their memory is released right away
+func crawlTestFiles(crawler *FilesCrawler) []*SecurityEventsContainer {
+	channel := make(chan *SecurityEventsContainer)
+	crawler.NextChannel = channel
+
+	done := make(chan []*SecurityEventsContainer)
+	go func() {
+		eventsContainers := make([]*SecurityEventsContainer, 0)
+		for eventsContainer := range channel {
+			crawler.Overload.Release([]*SecurityEventsContainer{eventsContainer})
+			eventsContainers = append(eventsContainers, eventsContainer)
+		}
+		done <- eventsContainers
+	}()
+
+	crawler._RunOnce()
+	close(channel)
+
+	return <-done
+}
+
+// testFileRule returns the shipped rule reading only the file
+func testFileRule(t testing.TB, name string, path string) *RuleConfig {
+	rule := *findTestRule(t, loadTestConfig(t), name)
+	rule.Paths = []string{path}
+	return &rule
+}
+
+func writeTestLog(t testing.TB, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFilesCrawlerOffsets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawler_files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lines := generateAuthLog(100)
+
+	tests := []struct {
+		name    string
+		content string
+		lines   int64
+	}{
+		{"lf", strings.Join(lines, "\n") + "\n", 100},
+		{"crlf", strings.Join(lines, "\r\n") + "\r\n", 100},
+		{"bom", "\xEF\xBB\xBF" + strings.Join(lines, "\n") + "\n", 100},
+		// the last line is read when the file is not modified for a while only
+		{"unterminated", strings.Join(lines, "\n"), 99},
+	}
+
+	for _, test := range tests {
+		path := writeTestLog(t, dir, test.name+".log", test.content)
+		crawler := newTestCrawler([]*RuleConfig{testFileRule(t, "sshd", path)})
+
+		eventsContainers := crawlTestFiles(crawler)
+		last := eventsContainers[len(eventsContainers)-1]
+
+		expectedOffset := int64(len(test.content))
+		if test.name == "unterminated" {
+			expectedOffset = int64(strings.LastIndex(test.content, "\n") + 1)
+		}
+
+		if last.Offset != expectedOffset || last.Line != test.lines+1 {
+			t.Errorf("%s: file is read to offset %d (line:%d), expected %d (line:%d)", test.name, last.Offset, last.Line, expectedOffset, test.lines+1)
+		}
+
+		// sources of events point to lines of the file
+		events := 0
+		for _, eventsContainer := range eventsContainers {
+			for _, securityEvent := range eventsContainer.SecurityEvents {
+				events++
+				if !strings.HasPrefix(*securityEvent.Source, path+":") {
+					t.Errorf("%s: unexpected source of event %s", test.name, *securityEvent.Source)
+				}
+			}
+		}
+		if events < 1 {
+			t.Errorf("%s: no events are found", test.name)
+		}
+	}
+}
+
+// the start position of a newly discovered file is committed, also when there is nothing to read after it
+func TestFilesCrawlerStartPosition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawler_files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := strings.Join(generateAuthLog(100), "\n") + "\n"
+
+	tests := []struct {
+		startPosition string
+		offset        int64
+		line          int64
+		events        int
+	}{
+		{"end", int64(len(content)), 101, 0},
+		{"beginning", int64(len(content)), 101, 39},
+	}
+
+	for _, test := range tests {
+		path := writeTestLog(t, dir, test.startPosition+".log", content)
+		rule := testFileRule(t, "sshd", path)
+		rule.StartPosition = test.startPosition
+		if err := rule.ParseStartPosition(); err != nil {
+			t.Fatal(err)
+		}
+
+		crawler := newTestCrawler([]*RuleConfig{rule})
+		crawler._firstRun = true
+
+		eventsContainers := crawlTestFiles(crawler)
+		if len(eventsContainers) < 1 {
+			t.Errorf("%s: start position is not committed", test.startPosition)
+			continue
+		}
+
+		first := eventsContainers[0]
+		if test.startPosition == "end" && (first.Offset != test.offset || first.Line != test.line) {
+			t.Errorf("%s: start position %d (line:%d) is committed, expected %d (line:%d)", test.startPosition, first.Offset, first.Line, test.offset, test.line)
+		}
+
+		events := 0
+		for _, eventsContainer := range eventsContainers {
+			events += len(eventsContainer.SecurityEvents)
+		}
+
+		last := eventsContainers[len(eventsContainers)-1]
+		if last.Offset != test.offset || last.Line != test.line || events != test.events {
+			t.Errorf("%s: file is read to offset %d (line:%d) with %d events, expected %d (line:%d) with %d events", test.startPosition, last.Offset, last.Line, events, test.offset, test.line, test.events)
+		}
+	}
+}
+
+// eventLine returns number of the line in the source of the event
+func eventLine(t *testing.T, securityEvent *SecurityEvent) int64 {
+	source := *securityEvent.Source
+	line, err := strconv.ParseInt(source[strings.LastIndex(source, ":")+1:], 10, 64)
+	if err != nil {
+		t.Fatalf("source of event %s doesn't contain the line", source)
+	}
+	return line
+}
+
+func TestFilesCrawlerChunks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawler_files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := strings.Join(generateAuthLog(1000), "\r\n") + "\r\n"
+	path := writeTestLog(t, dir, "auth.log", content)
+	rule := testFileRule(t, "sshd", path)
+
+	tests := []struct {
+		name        string
+		chunkEvents int
+		chunkBytes  int64
+	}{
+		{"events", 50, 0},
+		{"bytes", 100000, 4096},
+	}
+
+	for _, test := range tests {
+		crawler := newTestCrawler([]*RuleConfig{rule})
+		crawler.ChunkEvents = test.chunkEvents
+		crawler.ChunkBytes = test.chunkBytes
+		if test.chunkBytes <= 0 {
+			crawler.ChunkBytes = int64(len(content))
+		}
+
+		eventsContainers := crawlTestFiles(crawler)
+		if len(eventsContainers) < 5 {
+			t.Fatalf("%s: file is sent in %d chunks", test.name, len(eventsContainers))
+		}
+
+		allEvents := make([]string, 0)
+		var previousOffset int64 = 0
+		var previousLine int64 = 1
+
+		for i, eventsContainer := range eventsContainers {
+			last := i == len(eventsContainers)-1
+
+			if !last && len(eventsContainer.SecurityEvents) < test.chunkEvents && eventsContainer.Offset-previousOffset < test.chunkBytes {
+				t.Errorf("%s: chunk %d of %d events and %d bytes is sent before the limit", test.name, i, len(eventsContainer.SecurityEvents), eventsContainer.Offset-previousOffset)
+			}
+
+			// the checkpoint is at the beginning of the line with the number
+			offset := eventsContainer.Offset
+			if offset < previousOffset || offset > int64(len(content)) || (offset > 0 && content[offset-1] != '\n') {
+				t.Fatalf("%s: chunk %d checkpoints offset %d which is not at a line start", test.name, i, offset)
+			}
+			if line := int64(strings.Count(content[:offset], "\n")) + 1; eventsContainer.Line != line {
+				t.Errorf("%s: chunk %d checkpoints line %d at offset %d, expected line %d", test.name, i, eventsContainer.Line, offset, line)
+			}
+
+			// events of the chunk are found in lines before the checkpoint
+			for _, securityEvent := range eventsContainer.SecurityEvents {
+				line := eventLine(t, securityEvent)
+				if line < previousLine-1 || line >= eventsContainer.Line {
+					t.Errorf("%s: event of line %d is sent in chunk %d of lines %d-%d", test.name, line, i, previousLine, eventsContainer.Line)
+				}
+				allEvents = append(allEvents, *securityEvent.Source)
+			}
+
+			previousOffset = offset
+			previousLine = eventsContainer.Line
+		}
+
+		if previousOffset != int64(len(content)) {
+			t.Errorf("%s: file is read to offset %d of %d", test.name, previousOffset, len(content))
+		}
+
+		// the crawler restarted from a checkpoint sends the rest of the events
+		checkpoint := eventsContainers[len(eventsContainers)/2]
+		sentBefore := 0
+		for _, eventsContainer := range eventsContainers[:len(eventsContainers)/2+1] {
+			sentBefore += len(eventsContainer.SecurityEvents)
+		}
+
+		restarted := newTestCrawler([]*RuleConfig{rule})
+		restarted.ChunkEvents = crawler.ChunkEvents
+		restarted.ChunkBytes = crawler.ChunkBytes
+		sourceState := restarted.SystemState.Find(GetFileOsUniqueKey(path))
+		sourceState.Offset = checkpoint.Offset
+		sourceState.Line = checkpoint.Line
+
+		restartedEvents := make([]string, 0)
+		for _, eventsContainer := range crawlTestFiles(restarted) {
+			for _, securityEvent := range eventsContainer.SecurityEvents {
+				restartedEvents = append(restartedEvents, *securityEvent.Source)
+			}
+		}
+
+		if strings.Join(restartedEvents, ",") != strings.Join(allEvents[sentBefore:], ",") {
+			t.Errorf("%s: crawler restarted from offset %d (line:%d) sends %d events, expected %d events after the checkpoint", test.name, checkpoint.Offset, checkpoint.Line, len(restartedEvents), len(allEvents)-sentBefore)
+		}
+	}
+}
+
+// offsets of decoded lines are not known, so chunks keep the offset where reading started
+func TestFilesCrawlerDecodedChunks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawler_files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content, err := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder().String(strings.Join(generateAuthLog(1000), "\n") + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeTestLog(t, dir, "auth.log", content)
+
+	crawler := newTestCrawler([]*RuleConfig{testFileRule(t, "sshd", path)})
+	crawler.ChunkEvents = 50
+
+	eventsContainers := crawlTestFiles(crawler)
+	if len(eventsContainers) < 5 {
+		t.Fatalf("file is sent in %d chunks", len(eventsContainers))
+	}
+
+	events := 0
+	for i, eventsContainer := range eventsContainers {
+		events += len(eventsContainer.SecurityEvents)
+
+		if i < len(eventsContainers)-1 && (eventsContainer.Offset != 0 || eventsContainer.Line != 1) {
+			t.Errorf("chunk %d checkpoints offset %d (line:%d) of the decoded file", i, eventsContainer.Offset, eventsContainer.Line)
+		}
+	}
+
+	last := eventsContainers[len(eventsContainers)-1]
+	if last.Offset != int64(len(content)) || last.Line != 1001 {
+		t.Errorf("decoded file is read to offset %d (line:%d), expected %d (line:1001)", last.Offset, last.Line, len(content))
+	}
+
+	if events != 375 {
+		t.Errorf("%d events are found in the decoded file, expected 375", events)
+	}
+}
+
+func benchmarkCrawl(b *testing.B, ruleName string, lines []string) {
+	dir, err := ioutil.TempDir("", "crawler_files")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := strings.Join(lines, "\n") + "\n"
+	path := writeTestLog(b, dir, ruleName+".log", content)
+	crawler := newTestCrawler([]*RuleConfig{testFileRule(b, ruleName, path)})
+
+	b.SetBytes(int64(len(content)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		// the file is read from the beginning
+		crawler.SystemState = &SystemState{Ephemeral: true}
+		crawlTestFiles(crawler)
+	}
+}
+
+func BenchmarkCrawlAuthLog(b *testing.B) {
+	benchmarkCrawl(b, "sshd", generateAuthLog(100000))
+}
+
+func BenchmarkCrawlAccessLog(b *testing.B) {
+	benchmarkCrawl(b, "wordpress-accesslog", generateAccessLog(100000))
+}
@@ -7,6 +7,7 @@ type WinEventLogCrawler struct {
 	SystemState *SystemState
 	NextChannel chan *SecurityEventsContainer
 	Options     *Options
+	Overload    *OverloadGuard
 }
 
 func (crawler *WinEventLogCrawler) Init() {}
@@ -13,6 +13,7 @@ type WinEventLogCrawler struct {
 	SystemState            *SystemState
 	NextChannel            chan *SecurityEventsContainer
 	Options                *Options
+	Overload               *OverloadGuard
 	_winEventFieldsRegex   *regexp.Regexp
 	_defaultPeriodToParse  time.Duration
 	_maxNumberEventsToRead int
@@ -75,6 +76,14 @@ func (crawler *WinEventLogCrawler) _RunOnce() {
 				continue
 			}
 
+			// the period is counted from the last query after recovery from overload
+			if crawler.Overload.Throttled([]*RuleConfig{rule}) {
+				if crawler.Overload.Config.Policy == "pause" {
+					continue
+				}
+				crawler.Overload.WaitRecovered()
+			}
+
 			periodToParse := crawler._defaultPeriodToParse
 
 			sourceId := rule.RuleFileName + `_` + path
@@ -104,6 +113,9 @@ func (crawler *WinEventLogCrawler) _RunOnce() {
 							</Query>
 						</QueryList>`
 
+			// pause while the pipeline is over memory budget
+			crawler.Overload.Wait()
+
 			// make request to windows logs
 			result, err := _ReadEventLogs(query, crawler._maxNumberEventsToRead)
 			if err != nil {
@@ -202,7 +214,9 @@ func (crawler *WinEventLogCrawler) _RunOnce() {
 						Source:             &src,
 					}
 
-					eventsContainer.SecurityEvents = append(eventsContainer.SecurityEvents, securityEvent)
+					if crawler.Overload.Admit(rule.RuleFileName, securityEvent, eventsContainer) {
+						eventsContainer.SecurityEvents = append(eventsContainer.SecurityEvents, securityEvent)
+					}
 				}
 			}
 
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"time"
+
+	lumberjack "gopkg.in/natefinch/lumberjack.v2"
+)
+
+// DeadLetterRecord keeps the event which could not be delivered and the reason why
+type DeadLetterRecord struct {
+	Time   string       `json:"time"`
+	Output string       `json:"output"`
+	Reason string       `json:"reason"`
+	Event  *EventRecord `json:"event"`
+}
+
+// DeadLetterFile stores permanently failed events as json lines for manual investigation or replay
+type DeadLetterFile struct {
+	Path    string
+	_writer *lumberjack.Logger
+	_mutex  sync.Mutex
+}
+
+func (deadLetter *DeadLetterFile) Write(output string, reason string, event *EventRecord) {
+	deadLetter._mutex.Lock()
+	defer deadLetter._mutex.Unlock()
+
+	if deadLetter._writer == nil {
+		CreateDirIfNotExist(filepath.Dir(deadLetter.Path), StateDirMode)
+		deadLetter._writer = NewRotatingFile(deadLetter.Path, 0, 0, 0)
+	}
+
+	record := &DeadLetterRecord{
+		Time:   time.Now().UTC().Format(time.RFC3339),
+		Output: output,
+		Reason: reason,
+		Event:  event,
+	}
+
+	content, err := json.Marshal(record)
+	if err != nil {
+		emitLine(logLevel.important, "failed converting dead letter record to json. error: %s", err)
+		return
+	}
+
+	_, err = deadLetter._writer.Write(append(content, '\n'))
+	if err != nil {
+		emitLine(logLevel.important, "failed writing dead letter record to '%s'. event %d will be lost. error: %s", deadLetter.Path, event.Sid, err)
+	}
+}
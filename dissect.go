@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Dissect splits lines of a fixed layout by literal delimiters instead of a regex,
+// the pattern is like '%{ip} %{} %{user} [%{eventTime}] "%{method} %{uri} %{}" %{status}':
+// a key takes text until the first occurrence of the following delimiter or the rest of the line for the last key,
+// %{} and %{?name} skip the text, %{name->} skips repeated delimiters after the text, like padding spaces
+type Dissect struct {
+	_prefix []byte
+	_keys   []dissectKey
+}
+
+type dissectKey struct {
+	name      string
+	delimiter []byte
+	padding   bool
+}
+
+// CompileDissect parses the dissect pattern
+func CompileDissect(pattern string) (*Dissect, error) {
+	dissect := &Dissect{}
+
+	rest := pattern
+	start := strings.Index(rest, "%{")
+	if start < 0 {
+		return nil, errors.New(fmt.Sprintf("dissect pattern '%s' does not contain keys", pattern))
+	}
+	dissect._prefix = []byte(rest[:start])
+	rest = rest[start:]
+
+	for len(rest) > 0 {
+		end := strings.Index(rest, "}")
+		if end < 0 {
+			return nil, errors.New(fmt.Sprintf("key is not closed in dissect pattern '%s'", pattern))
+		}
+
+		key := dissectKey{
+			name: rest[2:end],
+		}
+		rest = rest[end+1:]
+
+		if strings.HasSuffix(key.name, "->") {
+			key.padding = true
+			key.name = strings.TrimSuffix(key.name, "->")
+		}
+
+		if strings.HasPrefix(key.name, "?") {
+			key.name = ""
+		}
+
+		start = strings.Index(rest, "%{")
+		if start < 0 {
+			start = len(rest)
+		} else if start == 0 {
+			return nil, errors.New(fmt.Sprintf("keys should be separated by a delimiter in dissect pattern '%s'", pattern))
+		}
+
+		key.delimiter = []byte(rest[:start])
+		rest = rest[start:]
+
+		dissect._keys = append(dissect._keys, key)
+	}
+
+	return dissect, nil
+}
+
+// Match checks if the line has the layout of the pattern
+func (dissect *Dissect) Match(line []byte) bool {
+	return dissect._Split(line, nil)
+}
+
+// Fill adds named keys of the matched line to the map, empty values are skipped like empty regex groups
+func (dissect *Dissect) Fill(line []byte, resultMap *map[string]string) bool {
+	return dissect._Split(line, func(name string, value []byte) {
+		if len(value) > 0 {
+			(*resultMap)[name] = string(value)
+		}
+	})
+}
+
+// RequiredLiterals returns the longest delimiter, every matched line contains it
+func (dissect *Dissect) RequiredLiterals() ([]string, bool) {
+	longest := dissect._prefix
+	for _, key := range dissect._keys {
+		if len(key.delimiter) > len(longest) {
+			longest = key.delimiter
+		}
+	}
+
+	if len(longest) < 1 {
+		return nil, false
+	}
+	return []string{string(longest)}, true
+}
+
+func (dissect *Dissect) _Split(line []byte, handler func(name string, value []byte)) bool {
+	if !bytes.HasPrefix(line, dissect._prefix) {
+		return false
+	}
+	position := len(dissect._prefix)
+
+	for _, key := range dissect._keys {
+		value := line[position:]
+
+		if len(key.delimiter) > 0 {
+			end := bytes.Index(value, key.delimiter)
+			if end < 0 {
+				return false
+			}
+			value = value[:end]
+			position += end + len(key.delimiter)
+
+			if key.padding {
+				for bytes.HasPrefix(line[position:], key.delimiter) {
+					position += len(key.delimiter)
+				}
+			}
+		} else {
+			position = len(line)
+		}
+
+		if handler != nil && len(key.name) > 0 {
+			handler(key.name, value)
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// the dissect pattern of the access log example and the regex extracting the same fields
+const (
+	accessLogDissect = `%{ip} %{} %{user} [%{eventTime}] "POST /wp-login.php%{}" 302 %{}`
+	accessLogRegex   = `^(?P<ip>.*?) .*? (?P<user>.*?) \[(?P<eventTime>.*?)\] "POST /wp-login\.php.*?" 302 `
+)
+
+func accessLogEvents(t testing.TB) (SecurityEventConfig, SecurityEventConfig) {
+	dissect, err := CompileDissect(accessLogDissect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := map[string]string{"app": "wordpress"}
+	return SecurityEventConfig{CompiledDissect: dissect, Fields: fields}, SecurityEventConfig{CompiledRegex: regexp.MustCompile(accessLogRegex), Fields: fields}
+}
+
+func TestDissectAccessLog(t *testing.T) {
+	dissectEvent, regexEvent := accessLogEvents(t)
+
+	lines := append(generateAccessLog(60),
+		`203.0.113.7 - admin [01/Mar/2026:00:00:00 +0000] "POST /wp-login.php HTTP/1.1" 302 1042 "-" "Mozilla/5.0"`,
+		`2001:db8::1 - - [01/Mar/2026:00:00:00 +0000] "POST /wp-login.php?redirect_to=%2F HTTP/2.0" 302 0`,
+		// the status is followed by the space
+		`203.0.113.7 - - [01/Mar/2026:00:00:00 +0000] "POST /wp-login.php HTTP/1.1" 302`,
+		`203.0.113.7 - - [01/Mar/2026:00:00:00 +0000] "POST /wp-login.php HTTP/1.1" 404 12`,
+		`203.0.113.7 - - 01/Mar/2026:00:00:00 +0000 "POST /wp-login.php HTTP/1.1" 302 12`,
+		`203.0.113.7 - - [01/Mar/2026:00:00:00 +0000] "GET /wp-login.php HTTP/1.1" 302 12`,
+		`203.0.113.7`,
+		``,
+	)
+
+	matched := 0
+	for _, line := range lines {
+		dissectResult := dissectEvent.Match([]byte(line))
+		regexResult := regexEvent.Match([]byte(line))
+
+		if !reflect.DeepEqual(dissectResult, regexResult) {
+			t.Errorf("dissect of %q is %v, regex is %v", line, dissectResult, regexResult)
+		}
+		if dissectResult != nil {
+			matched++
+		}
+	}
+
+	if matched != 12 {
+		t.Errorf("dissect matches %d lines, expected 12", matched)
+	}
+}
+
+func TestDissect(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		line    string
+		fields  map[string]string
+	}{
+		{"keys", "%{a} %{b} %{c}", "x y z", map[string]string{"a": "x", "b": "y", "c": "z"}},
+		// the last key takes the rest of the line
+		{"last key", "%{a} %{b}", "x y z", map[string]string{"a": "x", "b": "y z"}},
+		{"prefix", "<%{pri}>%{message}", "<13>hello", map[string]string{"pri": "13", "message": "hello"}},
+		{"prefix mismatch", "<%{pri}>%{message}", "13>hello", nil},
+		{"missing delimiter", "%{a} [%{b}]", "x y", nil},
+		// empty values are not added like empty regex groups
+		{"empty value", "%{a},%{b},%{c}", "x,,z", map[string]string{"a": "x", "c": "z"}},
+		{"skip", "%{a} %{} %{c}", "x y z", map[string]string{"a": "x", "c": "z"}},
+		{"named skip", "%{a} %{?b} %{c}", "x y z", map[string]string{"a": "x", "c": "z"}},
+		{"padding", "%{a->} %{b}", "x     y", map[string]string{"a": "x", "b": "y"}},
+		{"without padding", "%{a} %{b}", "x     y", map[string]string{"a": "x", "b": "    y"}},
+		{"padding of named skip", "%{?a->} %{b}", "x     y", map[string]string{"b": "y"}},
+		{"padding of long delimiter", "%{a->}, %{b}", "x, , , y", map[string]string{"a": "x", "b": "y"}},
+		// a trailing literal should be found after the last key, text after it is ignored
+		{"trailing literal", "%{a} [%{b}]", "x [y]", map[string]string{"a": "x", "b": "y"}},
+		{"trailing literal with text", "%{a} [%{b}]", "x [y] z", map[string]string{"a": "x", "b": "y"}},
+		{"trailing literal missing", "%{a} [%{b}]", "x [y", nil},
+	}
+
+	for _, test := range tests {
+		dissect, err := CompileDissect(test.pattern)
+		if err != nil {
+			t.Errorf("%s: failed compiling '%s': %s", test.name, test.pattern, err)
+			continue
+		}
+
+		if dissect.Match([]byte(test.line)) != (test.fields != nil) {
+			t.Errorf("%s: Match(%q) of '%s' = %v", test.name, test.line, test.pattern, test.fields == nil)
+			continue
+		}
+		if test.fields == nil {
+			continue
+		}
+
+		fields := make(map[string]string)
+		dissect.Fill([]byte(test.line), &fields)
+		if !reflect.DeepEqual(fields, test.fields) {
+			t.Errorf("%s: Fill(%q) of '%s' = %v; expected %v", test.name, test.line, test.pattern, fields, test.fields)
+		}
+	}
+}
+
+func TestCompileDissectErrors(t *testing.T) {
+	for _, pattern := range []string{
+		"",
+		"no keys",
+		"%{a}%{b}",
+		"%{a} %{b",
+		"%{a",
+		"prefix %{a} %{",
+	} {
+		if _, err := CompileDissect(pattern); err == nil {
+			t.Errorf("malformed dissect pattern '%s' is compiled", pattern)
+		}
+	}
+}
+
+func BenchmarkDissect(b *testing.B) {
+	dissectEvent, regexEvent := accessLogEvents(b)
+
+	lines := make([][]byte, 0)
+	for _, line := range generateAccessLog(10000) {
+		lines = append(lines, []byte(line))
+	}
+
+	for _, benchmark := range []struct {
+		name  string
+		event SecurityEventConfig
+	}{
+		{"dissect", dissectEvent},
+		{"regex", regexEvent},
+	} {
+		b.Run(benchmark.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				benchmark.event.Match(lines[i%len(lines)])
+			}
+		})
+	}
+}
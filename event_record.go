@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// EventRecord is a self-describing representation of a security event used by outputs other than the dhound gateway
+type EventRecord struct {
+	Sid       uint              `json:"sid"`
+	Gid       uint              `json:"gid,omitempty"`
+	Time      string            `json:"time"`
+	Ip        string            `json:"ip"`
+	Message   string            `json:"message,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	Critical  bool              `json:"critical,omitempty"`
+	Source    string            `json:"source,omitempty"`
+	SourceId  string            `json:"sourceid,omitempty"`
+	Host      string            `json:"host"`
+	ServerKey string            `json:"serverkey,omitempty"`
+}
+
+// HostIdentity identifies the agent host in records produced by outputs
+type HostIdentity struct {
+	Hostname  string
+	ServerKey string
+}
+
+func NewHostIdentity(config *MainConfig) *HostIdentity {
+	hostname, err := os.Hostname()
+	if err != nil {
+		emitLine(logLevel.important, "failed reading host name. error: %s", err)
+		hostname = "unknown"
+	}
+
+	return &HostIdentity{
+		Hostname:  hostname,
+		ServerKey: config.Output.ServerKey,
+	}
+}
+
+func NewEventRecord(securityEvent *SecurityEvent, eventsContainer *SecurityEventsContainer, host *HostIdentity) *EventRecord {
+	source := eventsContainer.Source
+	if securityEvent.Source != nil {
+		source = *securityEvent.Source
+	}
+
+	return &EventRecord{
+		Sid:       securityEvent.SecurityId,
+		Gid:       securityEvent.SecurityGroupId,
+		Time:      CustomLongToTime(securityEvent.EventTimeUtcNumber).UTC().Format(time.RFC3339),
+		Ip:        securityEvent.IpAddress,
+		Message:   securityEvent.Message,
+		Fields:    securityEvent.AdditionalFields,
+		Critical:  securityEvent.Critical,
+		Source:    source,
+		SourceId:  eventsContainer.SourceId,
+		Host:      host.Hostname,
+		ServerKey: host.ServerKey,
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"os"
+	"path/filepath"
 	"strings"
+
+	lumberjack "gopkg.in/natefinch/lumberjack.v2"
 )
 
 const (
@@ -34,3 +37,70 @@ func CreateDirIfNotExist(dir string, perm os.FileMode) error {
 	}
 	return nil
 }
+
+// WriteFileAtomic replaces the file by the content written to a temp file and synced to the disk,
+// the replaced file is renamed to backup if it's specified
+func WriteFileAtomic(filename string, backup string, content []byte, perm os.FileMode) error {
+	tempFile := filename + ".tmp"
+
+	file, err := os.OpenFile(tempFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(content)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+
+	if len(backup) > 0 && IsFileExists(filename) {
+		err = os.Rename(filename, backup)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.Rename(tempFile, filename)
+	if err != nil {
+		return err
+	}
+
+	return SyncDir(filepath.Dir(filename))
+}
+
+// rotation settings of the agent log, used as defaults for other rotated files
+const (
+	RotatingFileMaxSize    = 50 // megabytes
+	RotatingFileMaxBackups = 3
+	RotatingFileMaxAge     = 28 // days
+)
+
+// NewRotatingFile returns a writer which rotates the file by size, zero values take defaults of the agent log
+func NewRotatingFile(filename string, maxSize int, maxBackups int, maxAge int) *lumberjack.Logger {
+	if maxSize <= 0 {
+		maxSize = RotatingFileMaxSize
+	}
+	if maxBackups <= 0 {
+		maxBackups = RotatingFileMaxBackups
+	}
+	if maxAge <= 0 {
+		maxAge = RotatingFileMaxAge
+	}
+
+	return &lumberjack.Logger{
+		Filename:   filename,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+		MaxAge:     maxAge,
+	}
+}
@@ -3,6 +3,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"syscall"
@@ -34,3 +35,74 @@ func ReadOpen(path string) (*os.File, error) {
 	perm := os.FileMode(0)
 	return os.OpenFile(path, flag, perm)
 }
+
+func CheckSecretFilePermissions(path string, fileInfo os.FileInfo) error {
+	if fileInfo.Mode().Perm()&0044 != 0 {
+		return fmt.Errorf("secret file '%s' is readable by group or others (mode %04o), run: chmod 600 %s", path, fileInfo.Mode().Perm(), path)
+	}
+	return nil
+}
+
+// SyncDir flushes the directory entry, so a renamed file survives a crash
+func SyncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
+
+// LockFile opens the file and takes an exclusive lock released when the file is closed or the process exits
+func LockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// FixDirPermissions sets the mode of the directory created by the agent, umask can leave it without the execute bit or writable by others,
+// directories which existed before are not changed
+func FixDirPermissions(dir string, perm os.FileMode) error {
+	fileInfo, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+
+	mode := fileInfo.Mode().Perm()
+	if mode&0700 == 0700 && mode&0002 == 0 {
+		return nil
+	}
+
+	emitLine(logLevel.important, "permissions of directory '%s' are changed from %04o to %04o", dir, mode, perm)
+	return os.Chmod(dir, perm)
+}
+
+// CheckDirPermissions warns when the existing directory is writable by other users, its mode is never changed,
+// as it can be a shared directory like /tmp
+func CheckDirPermissions(dir string) error {
+	fileInfo, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+
+	if !fileInfo.IsDir() {
+		return errors.New(fmt.Sprintf("'%s' is not a directory", dir))
+	}
+
+	mode := fileInfo.Mode().Perm()
+	if mode&0002 != 0 {
+		emitLine(logLevel.important, "directory '%s' is writable by other users (%04o), a directory owned by the agent is recommended", dir, mode)
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"time"
+)
+
+// files are bisected by offsets until the range is smaller than the block, then it's scanned line by line
+const fileSearchBlockSize = 64 * 1024
+
+// lineEventTime returns the event time of the line, tests replace it to count searched lines
+var lineEventTime = LineEventTime
+
+// FindOffsetSince returns offset and number of the first line with an event time since the time,
+// events in the file are expected to be ordered by time, so the file is searched by bisection,
+// lines without events are skipped
+func FindOffsetSince(path string, since time.Time, rules []*RuleConfig) (int64, int64, error) {
+	file, err := ReadOpen(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// the first line since the time starts after low and before high or it's the line at next
+	var low int64 = 0
+	high := fileInfo.Size()
+	var next int64 = -1
+
+	for high-low > fileSearchBlockSize {
+		middle := low + (high-low)/2
+
+		lineStart, lineEnd, eventTime, found, err := findTimedLine(file, middle, high, rules)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if !found {
+			// no events in the second half, the line is in the first one or it's the next found line
+			high = middle
+		} else if eventTime.Before(since) {
+			low = lineEnd
+		} else {
+			high = lineStart
+			next = lineStart
+		}
+	}
+
+	// lines after high are already searched, so only the rest of the range is scanned
+	offset := next
+	err = scanLines(file, low, high, func(lineStart int64, segment []byte) bool {
+		eventTime, timed := lineEventTime(bytes.TrimRight(segment, "\r\n"), rules)
+		if timed && !eventTime.Before(since) {
+			offset = lineStart
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if offset < 0 {
+		// all events are older, the file is processed after its last complete line
+		emitLine(logLevel.verbose, "no events since %s are found in file '%s'", since.Format(time.RFC3339), path)
+
+		offset, err = lastLineEnd(file, fileInfo.Size())
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	linePosition, err := countLines(file, offset)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return offset, linePosition, nil
+}
+
+// lastLineEnd returns offset after the last new line symbol of the file, the file is read from the end
+func lastLineEnd(file *os.File, size int64) (int64, error) {
+	buffer := make([]byte, fileSearchBlockSize)
+
+	for end := size; end > 0; {
+		start := end - int64(len(buffer))
+		if start < 0 {
+			start = 0
+		}
+
+		n, err := file.ReadAt(buffer[:end-start], start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		if i := bytes.LastIndexByte(buffer[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+
+	return 0, nil
+}
+
+// CountLines returns number of the line starting at the offset
+func CountLines(path string, offset int64) (int64, error) {
+	file, err := ReadOpen(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return countLines(file, offset)
+}
+
+func countLines(file *os.File, offset int64) (int64, error) {
+	reader := io.NewSectionReader(file, 0, offset)
+	buffer := make([]byte, 64*1024)
+
+	var linePosition int64 = 1
+	for {
+		n, err := reader.Read(buffer)
+		linePosition += int64(bytes.Count(buffer[:n], []byte{'\n'}))
+
+		if err == io.EOF {
+			return linePosition, nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// findTimedLine returns bounds and event time of the first line with an event, starting in the range
+func findTimedLine(file *os.File, from int64, to int64, rules []*RuleConfig) (int64, int64, time.Time, bool, error) {
+	var lineStart, lineEnd int64
+	var eventTime time.Time
+	found := false
+
+	err := scanLines(file, from, to, func(start int64, segment []byte) bool {
+		eventTime, found = lineEventTime(bytes.TrimRight(segment, "\r\n"), rules)
+		lineStart = start
+		lineEnd = start + int64(len(segment))
+		return !found
+	})
+
+	return lineStart, lineEnd, eventTime, found, err
+}
+
+// scanLines calls the handler for lines starting in the range until it returns false,
+// a line is started after the new line symbol, so the line containing the first offset is skipped
+func scanLines(file *os.File, from int64, to int64, handler func(lineStart int64, segment []byte) bool) error {
+	offset := from
+	if from > 0 {
+		offset = from - 1
+	}
+
+	reader := bufio.NewReaderSize(io.NewSectionReader(file, offset, 1<<62), 10*1024)
+
+	if from > 0 {
+		segment, err := reader.ReadBytes('\n')
+		offset += int64(len(segment))
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	for offset < to {
+		segment, err := reader.ReadBytes('\n')
+
+		if len(segment) > 0 && !handler(offset, segment) {
+			return nil
+		}
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		offset += int64(len(segment))
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// syslogLines returns sshd lines of auth.log written every step since the time, without the year in timestamps
+func syslogLines(start time.Time, step time.Duration, count int) []string {
+	lines := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		timestamp := start.Add(time.Duration(i) * step).Format(time.Stamp)
+		lines = append(lines, fmt.Sprintf("%s web1 sshd[%d]: Failed password for invalid user u%d from 10.0.%d.%d port %d ssh2", timestamp, 1000+i%5000, i, i%250, i%200, 1024+i%60000))
+	}
+	return lines
+}
+
+// untimedLines returns lines not matched by the sshd rule
+func untimedLines(count int) []string {
+	lines := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		lines = append(lines, fmt.Sprintf("kernel message %d without a timestamp, it's not an event of the rule", i))
+	}
+	return lines
+}
+
+// lineOffset returns offset of the line with the index
+func lineOffset(lines []string, index int) int64 {
+	if index == 0 {
+		return 0
+	}
+	return int64(len(strings.Join(lines[:index], "\n")) + 1)
+}
+
+func TestFindOffsetSince(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_position")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rules := []*RuleConfig{findTestRule(t, loadTestConfig(t), "sshd")}
+
+	// lines of the last new year, every 10 seconds from the noon of December 31
+	// December lines are of the previous year until the middle of December 30
+	now := time.Now()
+	newYear := time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, time.Local)
+	yearLines := syslogLines(newYear.Add(-12*time.Hour), 10*time.Second, 8640)
+
+	// a block of untimed lines, longer than the search block, is in the middle of events
+	mixedStart := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.Local)
+	if mixedStart.After(now) {
+		mixedStart = mixedStart.AddDate(-1, 0, 0)
+	}
+	mixedLines := append(syslogLines(mixedStart, time.Second, 2000), untimedLines(3000)...)
+	mixedLines = append(mixedLines, syslogLines(mixedStart.Add(time.Hour), time.Second, 2000)...)
+	// untimed lines between events are skipped
+	for i := 1; i < len(mixedLines); i += 7 {
+		mixedLines[i] = "-- MARK --"
+	}
+
+	tests := []struct {
+		name   string
+		lines  []string
+		since  time.Time
+		offset int64
+		line   int64
+	}{
+		{"year boundary", yearLines, newYear.Add(5 * time.Second), lineOffset(yearLines, 4321), 4322},
+		{"year boundary before", yearLines, newYear.Add(-24 * time.Hour), 0, 1},
+		{"year boundary after", yearLines, newYear.Add(24 * time.Hour), lineOffset(yearLines, len(yearLines)), int64(len(yearLines)) + 1},
+		{"untimed lines before", mixedLines, mixedStart.Add(40 * time.Minute), lineOffset(mixedLines, 5000), 5001},
+		{"untimed lines after", mixedLines, mixedStart.Add(time.Hour + 100*time.Second + 500*time.Millisecond), lineOffset(mixedLines, 5101), 5102},
+		{"untimed lines only", untimedLines(5000), mixedStart, lineOffset(untimedLines(5000), 5000), 5001},
+		{"empty", []string{}, mixedStart, 0, 1},
+	}
+
+	for _, test := range tests {
+		if strings.HasPrefix(test.name, "year boundary") && now.After(newYear.AddDate(1, 0, 0).Add(-36*time.Hour)) {
+			t.Logf("%s: the year of lines is ambiguous at %s", test.name, now.Format(time.Stamp))
+			continue
+		}
+
+		content := ""
+		if len(test.lines) > 0 {
+			content = strings.Join(test.lines, "\n") + "\n"
+		}
+		path := writeTestLog(t, dir, strings.Replace(test.name, " ", "_", -1)+".log", content)
+
+		offset, line, err := FindOffsetSince(path, test.since, rules)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		if offset != test.offset || line != test.line {
+			t.Errorf("%s: offset since %s is %d (line:%d), expected %d (line:%d)", test.name, test.since.Format(time.Stamp), offset, line, test.offset, test.line)
+		}
+	}
+}
+
+// lines of the tail without events are searched once, the search doesn't scan the file from the last older event again
+func TestFindOffsetSinceUntimedTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_position")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rules := []*RuleConfig{findTestRule(t, loadTestConfig(t), "sshd")}
+
+	start := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.Local)
+	if start.After(time.Now()) {
+		start = start.AddDate(-1, 0, 0)
+	}
+	lines := append(syslogLines(start, time.Second, 2000), untimedLines(40000)...)
+	// the last line is not complete
+	content := strings.Join(lines, "\n") + "\n" + "kernel: partial"
+	path := writeTestLog(t, dir, "tail.log", content)
+
+	searched := 0
+	lineEventTime = func(line []byte, rules []*RuleConfig) (time.Time, bool) {
+		searched++
+		return LineEventTime(line, rules)
+	}
+	defer func() { lineEventTime = LineEventTime }()
+
+	tests := []struct {
+		name   string
+		since  time.Time
+		offset int64
+		line   int64
+	}{
+		{"events", start.Add(1500 * time.Second), lineOffset(lines, 1500), 1501},
+		{"no events", start.Add(time.Hour), lineOffset(lines, len(lines)), int64(len(lines)) + 1},
+	}
+
+	for _, test := range tests {
+		searched = 0
+		offset, line, err := FindOffsetSince(path, test.since, rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if offset != test.offset || line != test.line {
+			t.Errorf("%s: offset since %s is %d (line:%d), expected %d (line:%d)", test.name, test.since.Format(time.Stamp), offset, line, test.offset, test.line)
+		}
+		if searched > len(lines)+1 {
+			t.Errorf("%s: %d lines are searched in the file of %d lines", test.name, searched, len(lines)+1)
+		}
+	}
+}
@@ -62,3 +62,38 @@ func ReadOpen(path string) (*os.File, error) {
 
 	return os.NewFile(uintptr(handle), path), nil
 }
+
+func CheckSecretFilePermissions(path string, fileInfo os.FileInfo) error {
+	// access to files is controlled by ACL on windows, unix mode bits are not meaningful
+	return nil
+}
+
+// SyncDir is not needed on windows, renamed files are flushed with the file system metadata
+func SyncDir(dir string) error {
+	return nil
+}
+
+// LockFile opens the file without sharing, so nobody else can open it until it's closed or the process exits
+func LockFile(path string) (*os.File, error) {
+	pathp, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error converting to UTF16: %v", err)
+	}
+
+	handle, err := syscall.CreateFile(pathp, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(handle), path), nil
+}
+
+// FixDirPermissions is not needed on windows, access is inherited from the parent directory
+func FixDirPermissions(dir string, perm os.FileMode) error {
+	return nil
+}
+
+// CheckDirPermissions is not needed on windows, access is inherited from the parent directory
+func CheckDirPermissions(dir string) error {
+	return nil
+}
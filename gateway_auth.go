@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// the token is sent in the Authorization header only
+	GatewayAuthModeHeader = "header"
+	// the token is sent in the Authorization header and in the json body (compatible with old collectors)
+	GatewayAuthModeBody = "body"
+)
+
+// GatewayAuth authenticates requests to the collector: the access token is sent in the Authorization header
+// and the body is signed by HMAC-SHA256 together with a timestamp and a nonce to detect tampering and replay
+type GatewayAuth struct {
+	Mode        string
+	AccessToken string
+	SigningKey  string
+}
+
+func NewGatewayAuth(config *OutputConfig) (*GatewayAuth, error) {
+	mode := strings.ToLower(config.AuthMode)
+	if mode == "" {
+		mode = GatewayAuthModeBody
+	}
+
+	if mode != GatewayAuthModeHeader && mode != GatewayAuthModeBody {
+		return nil, errors.New(fmt.Sprintf("auth mode '%s' is not supported, use header or body", config.AuthMode))
+	}
+
+	signingKey := config.SigningKey
+	if len(signingKey) < 1 {
+		signingKey = config.AccessToken
+	}
+
+	return &GatewayAuth{
+		Mode:        mode,
+		AccessToken: config.AccessToken,
+		SigningKey:  signingKey,
+	}, nil
+}
+
+// Body returns the request body for the spooled message content, the token is set or removed according to the mode
+func (auth *GatewayAuth) Body(content []byte) ([]byte, error) {
+	serverMessage := ServerRequestMessage{}
+	err := json.Unmarshal(content, &serverMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	serverMessage.AccessToken = ""
+	if auth.Mode == GatewayAuthModeBody {
+		serverMessage.AccessToken = auth.AccessToken
+	}
+
+	return json.Marshal(serverMessage)
+}
+
+// Sign adds authorization and signature headers, every request gets its own nonce
+func (auth *GatewayAuth) Sign(request *http.Request, body []byte) {
+	timestamp := I64toa(time.Now().UTC().Unix())
+	nonce := NewUuid()
+
+	request.Header.Set("Authorization", "Bearer "+auth.AccessToken)
+	request.Header.Set("X-DHound-Timestamp", timestamp)
+	request.Header.Set("X-DHound-Nonce", nonce)
+	request.Header.Set("X-DHound-Signature", auth.Signature(timestamp, nonce, body))
+}
+
+// Signature returns hex HMAC-SHA256 of "timestamp\nnonce\nbody"
+func (auth *GatewayAuth) Signature(timestamp string, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(auth.SigningKey))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(nonce))
+	mac.Write([]byte{'\n'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGatewayAuthSignature(t *testing.T) {
+	auth, err := NewGatewayAuth(&OutputConfig{AccessToken: "token", SigningKey: "signing-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// hex HMAC-SHA256 of "timestamp\nnonce\nbody" by the signing key
+	signature := auth.Signature("1772532942", "nonce-1", []byte(`{"events":[]}`))
+	if signature != "76bd2f3c332ebf88c6ab78d558a091dd6cf0679bb7c3d667ec714e1fd0103fa3" {
+		t.Errorf("signature is %s", signature)
+	}
+
+	for _, changed := range [][]string{{"1772532943", "nonce-1", `{"events":[]}`}, {"1772532942", "nonce-2", `{"events":[]}`}, {"1772532942", "nonce-1", `{"events":[1]}`}} {
+		if auth.Signature(changed[0], changed[1], []byte(changed[2])) == signature {
+			t.Errorf("signature of changed request %v is the same", changed)
+		}
+	}
+
+	// the access token is the signing key by default
+	auth, _ = NewGatewayAuth(&OutputConfig{AccessToken: "signing-key"})
+	if auth.Signature("1772532942", "nonce-1", []byte(`{"events":[]}`)) != signature {
+		t.Errorf("request is not signed by the access token")
+	}
+}
+
+func TestNewGatewayAuth(t *testing.T) {
+	tests := []struct {
+		mode     string
+		expected string
+		err      bool
+	}{
+		{"", GatewayAuthModeBody, false},
+		{"Header", GatewayAuthModeHeader, false},
+		{"body", GatewayAuthModeBody, false},
+		{"query", "", true},
+	}
+
+	for _, test := range tests {
+		auth, err := NewGatewayAuth(&OutputConfig{AccessToken: "token", AuthMode: test.mode})
+		if test.err {
+			if err == nil {
+				t.Errorf("auth mode '%s' is accepted", test.mode)
+			}
+			continue
+		}
+
+		if err != nil || auth.Mode != test.expected {
+			t.Errorf("auth mode '%s' is %v (%v), expected %s", test.mode, auth, err, test.expected)
+		}
+	}
+}
+
+// the token is kept out of spooled messages and is added to the body only in the body mode,
+// the collector verifies the signature of the body it receives
+func TestGatewayAuthPost(t *testing.T) {
+	for _, mode := range []string{GatewayAuthModeHeader, GatewayAuthModeBody} {
+		received := make(chan *http.Request, 2)
+		bodies := make(chan []byte, 2)
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			body, _ := ioutil.ReadAll(request.Body)
+			received <- request
+			bodies <- body
+			writer.Write([]byte(`{"success":true}`))
+		}))
+
+		config := &OutputConfig{AccessToken: "token", SigningKey: "signing-key", AuthMode: mode, Url: server.URL}
+		auth, err := NewGatewayAuth(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		endpoints, err := NewGatewayEndpoints(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gate := HttpGateway{_auth: auth, _endpoints: endpoints}
+
+		// spooled content doesn't have the token
+		content, _ := json.Marshal(&ServerRequestMessage{ServerKey: "server", Events: []*SecurityEvent{{SecurityId: 10002}}})
+		for i := 0; i < 2; i++ {
+			resp, err := gate._Post(http.DefaultClient, content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+		}
+		server.Close()
+
+		nonces := make(map[string]bool)
+		for i := 0; i < 2; i++ {
+			request, body := <-received, <-bodies
+
+			if request.Header.Get("Authorization") != "Bearer token" {
+				t.Errorf("%s: request is authorized by '%s'", mode, request.Header.Get("Authorization"))
+			}
+
+			timestamp := request.Header.Get("X-DHound-Timestamp")
+			seconds, _ := strconv.ParseInt(timestamp, 10, 64)
+			if time.Since(time.Unix(seconds, 0)) > time.Minute {
+				t.Errorf("%s: timestamp of the request is '%s'", mode, timestamp)
+			}
+
+			nonce := request.Header.Get("X-DHound-Nonce")
+			if len(nonce) < 1 || nonces[nonce] {
+				t.Errorf("%s: nonce '%s' of the request is not unique", mode, nonce)
+			}
+			nonces[nonce] = true
+
+			if request.Header.Get("X-DHound-Signature") != auth.Signature(timestamp, nonce, body) {
+				t.Errorf("%s: signature doesn't match the body %s", mode, body)
+			}
+
+			if strings.Contains(string(body), `"token":"token"`) != (mode == GatewayAuthModeBody) {
+				t.Errorf("%s: body of the request is %s", mode, body)
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const gatewayProductionUrl = "https://gate.dhound.io/collect"
+
+// environment names supported before explicit url setting, kept as aliases
+var gatewayEnvironmentUrls = map[string]string{
+	"":           gatewayProductionUrl,
+	"PROD":       gatewayProductionUrl,
+	"PRODUCTION": gatewayProductionUrl,
+	"TEST":       "https://gate-test.dhound.io/collect",
+	"DEV":        "http://localhost:5000/collect",
+}
+
+const (
+	gatewayEndpointMinBackoff = 30 * time.Second
+	gatewayEndpointMaxBackoff = 10 * time.Minute
+)
+
+// GatewayEndpoint is a collector url with its health
+type GatewayEndpoint struct {
+	Url             string
+	_failures       uint
+	_unhealthyUntil time.Time
+}
+
+// GatewayEndpoints selects the collector url: the first healthy endpoint in the configured order is used,
+// failed endpoints are skipped for an exponentially growing period
+type GatewayEndpoints struct {
+	Endpoints []*GatewayEndpoint
+	_mutex    sync.Mutex
+}
+
+func NewGatewayEndpoints(config *OutputConfig) (*GatewayEndpoints, error) {
+	urls := make([]string, 0)
+
+	if len(config.Url) > 0 {
+		urls = append(urls, config.Url)
+	} else {
+		environmentUrl, found := gatewayEnvironmentUrls[strings.ToUpper(strings.TrimSpace(config.Environment))]
+		if !found {
+			return nil, errors.New(fmt.Sprintf("environment '%s' is not supported, specify url of the collector instead", config.Environment))
+		}
+		urls = append(urls, environmentUrl)
+	}
+
+	urls = append(urls, config.FailoverUrls...)
+
+	endpoints := &GatewayEndpoints{}
+	for _, endpointUrl := range urls {
+		err := ValidateGatewayUrl(endpointUrl)
+		if err != nil {
+			return nil, err
+		}
+		endpoints.Endpoints = append(endpoints.Endpoints, &GatewayEndpoint{Url: endpointUrl})
+	}
+
+	return endpoints, nil
+}
+
+func ValidateGatewayUrl(endpointUrl string) error {
+	parsedUrl, err := url.Parse(endpointUrl)
+	if err != nil {
+		return errors.New(fmt.Sprintf("incorrect collector url '%s': %s", endpointUrl, err))
+	}
+
+	if parsedUrl.Scheme != "https" && parsedUrl.Scheme != "http" {
+		return errors.New(fmt.Sprintf("incorrect collector url '%s': only http and https are supported", endpointUrl))
+	}
+
+	if len(parsedUrl.Host) < 1 {
+		return errors.New(fmt.Sprintf("incorrect collector url '%s': host is not specified", endpointUrl))
+	}
+
+	return nil
+}
+
+// Ordered returns healthy endpoints in the configured order followed by unhealthy ones, the soonest to recover first
+func (endpoints *GatewayEndpoints) Ordered() []*GatewayEndpoint {
+	endpoints._mutex.Lock()
+	defer endpoints._mutex.Unlock()
+
+	now := time.Now()
+	healthy := make([]*GatewayEndpoint, 0, len(endpoints.Endpoints))
+	unhealthy := make([]*GatewayEndpoint, 0)
+
+	for _, endpoint := range endpoints.Endpoints {
+		if now.Before(endpoint._unhealthyUntil) {
+			unhealthy = append(unhealthy, endpoint)
+		} else {
+			healthy = append(healthy, endpoint)
+		}
+	}
+
+	for i := 1; i < len(unhealthy); i++ {
+		for j := i; j > 0 && unhealthy[j]._unhealthyUntil.Before(unhealthy[j-1]._unhealthyUntil); j-- {
+			unhealthy[j], unhealthy[j-1] = unhealthy[j-1], unhealthy[j]
+		}
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+func (endpoints *GatewayEndpoints) ReportSuccess(endpoint *GatewayEndpoint) {
+	endpoints._mutex.Lock()
+	defer endpoints._mutex.Unlock()
+
+	if endpoint._failures > 0 {
+		emitLine(logLevel.important, "collector %s is available again.", endpoint.Url)
+	}
+
+	endpoint._failures = 0
+	endpoint._unhealthyUntil = time.Time{}
+}
+
+func (endpoints *GatewayEndpoints) ReportFailure(endpoint *GatewayEndpoint) {
+	endpoints._mutex.Lock()
+	defer endpoints._mutex.Unlock()
+
+	endpoint._failures++
+
+	backoff := gatewayEndpointMinBackoff
+	for i := uint(1); i < endpoint._failures && backoff < gatewayEndpointMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > gatewayEndpointMaxBackoff {
+		backoff = gatewayEndpointMaxBackoff
+	}
+
+	endpoint._unhealthyUntil = time.Now().Add(backoff)
+}
+
+func (endpoints *GatewayEndpoints) String() string {
+	urls := make([]string, 0, len(endpoints.Endpoints))
+	for _, endpoint := range endpoints.Endpoints {
+		urls = append(urls, endpoint.Url)
+	}
+	return strings.Join(urls, ", ")
+}
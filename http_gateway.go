@@ -2,21 +2,24 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"io/ioutil"
-	"os"
-	"path/filepath"
-	"sort"
 	"time"
 
-	"crypto/tls"
 	"net/http"
 	"net/url"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 )
 
 type ServerRequestMessage struct {
-	AccessToken              string              `json:"token"`
+	AccessToken              string              `json:"token,omitempty"`
+	BatchId                  string              `json:"bid,omitempty"`
 	ServerKey                string              `json:"hd"`
 	LocalTimeUtcNumber       int64               `json:"ult"`
 	LocalTimeUtcOffsetNumber int                 `json:"ulto,omitempty"`
@@ -26,20 +29,52 @@ type ServerRequestMessage struct {
 }
 
 type ServerResponseMessage struct {
-	Success      bool   `json:"success,omitempty"`
-	ErrorMessage string `json:"error,omitempty"`
-	ErrorCode    int    `json:"errorcode,omitempty"`
+	Success      bool            `json:"success,omitempty"`
+	ErrorMessage string          `json:"error,omitempty"`
+	ErrorCode    int             `json:"errorcode,omitempty"`
+	Rejected     []RejectedEvent `json:"rejected,omitempty"`
+}
+
+// RejectedEvent points to the event of the request which was not accepted by the collector
+type RejectedEvent struct {
+	Index     int    `json:"i"`
+	Reason    string `json:"reason,omitempty"`
+	Retryable bool   `json:"retry,omitempty"`
 }
 
+// number of events rejected by the collector per rule and sid, available on /debug/vars with -pprof option
+var gatewayRejectedEvents = expvar.NewMap("gateway_rejected_events")
+
 type HttpGateway struct {
 	Input                chan []*SecurityEventsContainer `json:- yaml:-`
+	CriticalInput        chan []*SecurityEventsContainer
 	SystemState          *SystemState
 	Options              *Options
 	MainConfig           *MainConfig
-	_serverUrl           string
+	Overload             *OverloadGuard
+	_endpoints           *GatewayEndpoints
+	_auth                *GatewayAuth
+	_spool               *Spool
+	_criticalSpool       *Spool
+	_deadLetter          *DeadLetterFile
+	_host                *HostIdentity
+	_ruleNamesBySid      map[uint]string
 	_timeOffsetInSeconds int
 	_client              *http.Client
+	_criticalClient      *http.Client
 	_firstMessageSent    bool
+	_inFlight            *gatewayInFlight
+}
+
+// gatewayInFlight tracks batches sent concurrently
+type gatewayInFlight struct {
+	slots      chan struct{}
+	mutex      sync.Mutex
+	nextCommit uint64
+	completed  map[uint64][]*SecurityEventsContainer
+	resending  int32
+	// critical events are resent by one request at a time too
+	resendingCritical int32
 }
 
 func (gate *HttpGateway) Init() {
@@ -50,82 +85,232 @@ func (gate *HttpGateway) Init() {
 
 	gate._timeOffsetInSeconds = timeOffsetInSeconds
 
-	serverUrl := "https://gate.dhound.io/collect"
-
 	config := gate.MainConfig.Output
 
-	if config.Environment == "DEV" {
-		serverUrl = "http://localhost:5000/collect"
-	} else if config.Environment == "TEST" {
-		serverUrl = "https://gate-test.dhound.io/collect"
-	} else {
-		serverUrl = "https://gate.dhound.io/collect"
-		//exit(exitStat.faulted, "Environment %s not supported\n", config.Environment)
+	endpoints, err := NewGatewayEndpoints(&config)
+	if err != nil {
+		exit(exitStat.faulted, "Failed configuring collector url: %s\n", err)
+		return
 	}
 
-	gate._serverUrl = serverUrl
+	gate._endpoints = endpoints
+
+	auth, err := NewGatewayAuth(&config)
+	if err != nil {
+		exit(exitStat.faulted, "Failed configuring collector authentication: %s\n", err)
+		return
+	}
+
+	gate._auth = auth
+
+	gate._spool = &Spool{Dir: SpoolDir, Prefix: ".net_"}
+	gate._criticalSpool = &Spool{Dir: SpoolDir, Prefix: ".critical_"}
+	gate._deadLetter = &DeadLetterFile{Path: filepath.Join(StateDir, "deadletter-gateway.json")}
+	gate._host = NewHostIdentity(gate.MainConfig)
+	gate._ruleNamesBySid = RuleNamesBySid(gate.MainConfig.Input.RuleConfigs)
+	gate._inFlight = &gatewayInFlight{
+		slots:     make(chan struct{}, config.MaxInFlight),
+		completed: make(map[uint64][]*SecurityEventsContainer),
+	}
 
 	proxy := config.Proxy
 	//emit(logLevel.verbose, proxy)
 
 	if len(proxy) > 0 {
-		emit(logLevel.verbose, "Server url: %s via proxy: %s\n", gate._serverUrl, proxy)
+		emit(logLevel.verbose, "Server url: %s via proxy: %s\n", endpoints, proxy)
 	} else {
-		emit(logLevel.verbose, "Server url: %s\n", serverUrl)
+		emit(logLevel.verbose, "Server url: %s\n", endpoints)
 	}
 
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{},
-		//		DialContext: (&net.Dialer{
-		//			Timeout:   30 * time.Second, // default: 30
-		//			KeepAlive: 0,                // default: 30
-		//		}).DialContext,
-		DisableKeepAlives:  true,
-		MaxIdleConns:       -1,               // default: 100
-		IdleConnTimeout:    10 * time.Second, // default: 90
-		DisableCompression: true,
+	tlsConfig, err := NewTlsClientConfig(config.Tls)
+	if err != nil {
+		exit(exitStat.faulted, "Failed configuring tls: %s\n", err)
+		return
 	}
 
+	var proxyUrl *url.URL
 	if len(proxy) > 0 {
 
-		proxyUrl, err := url.Parse(proxy)
+		proxyUrl, err = url.Parse(proxy)
 		if err != nil {
 			emit(logLevel.critical, "Error parsing proxy URL: %s, error: %s\n", proxy, err.Error())
 			panic("Exit!")
 		}
-
-		transport.Proxy = http.ProxyURL(proxyUrl)
 	}
 
+	transport := newGatewayTransport(tlsConfig, proxyUrl)
+
 	client := &http.Client{
 		Transport: transport,
 		Timeout:   time.Duration(time.Millisecond * 15000),
 	}
 
 	gate._client = client
+
+	// critical events have own connection, so they never wait for a slow bulk request
+	gate._criticalClient = &http.Client{
+		Transport: newGatewayTransport(tlsConfig, proxyUrl),
+		Timeout:   config.Batch.fastPathTimeout,
+	}
+}
+
+func newGatewayTransport(tlsConfig *tls.Config, proxyUrl *url.URL) *http.Transport {
+	transport := &http.Transport{
+		TLSClientConfig: tlsConfig,
+		//		DialContext: (&net.Dialer{
+		//			Timeout:   30 * time.Second, // default: 30
+		//			KeepAlive: 0,                // default: 30
+		//		}).DialContext,
+		DisableKeepAlives:  true,
+		MaxIdleConns:       -1,               // default: 100
+		IdleConnTimeout:    10 * time.Second, // default: 90
+		DisableCompression: true,
+	}
+
+	if proxyUrl != nil {
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	return transport
+}
+
+// RunCritical sends critical events of the fast path one batch at a time
+func (gate *HttpGateway) RunCritical() {
+	for eventsContainers := range gate.CriticalInput {
+		gate.SendCriticalToServer(eventsContainers)
+	}
 }
 
 func (gate *HttpGateway) Run() {
 
+	var batchNumber uint64 = 0
+
 	// wait events from channel input
 	for eventsContainers := range gate.Input {
 		// debug("GATE: try to send %d events.", len(eventsContainers))
 
-		gate.SendToServer(eventsContainers)
+		// wait for a free slot, up to MaxInFlight requests are sent at the same time
+		gate._inFlight.slots <- struct{}{}
+
+		go func(batchNumber uint64, eventsContainers []*SecurityEventsContainer) {
+			gate.SendToServer(eventsContainers)
+			<-gate._inFlight.slots
+
+			gate._Commit(batchNumber, eventsContainers)
+		}(batchNumber, eventsContainers)
+
+		batchNumber++
+	}
+}
+
+// _Commit syncs source state in the order batches were received, so offset of a file is never moved
+// past a batch which is still in flight
+func (gate *HttpGateway) _Commit(batchNumber uint64, eventsContainers []*SecurityEventsContainer) {
+	inFlight := gate._inFlight
+
+	inFlight.mutex.Lock()
+	defer inFlight.mutex.Unlock()
+
+	inFlight.completed[batchNumber] = eventsContainers
+
+	for {
+		completedContainers, found := inFlight.completed[inFlight.nextCommit]
+		if !found {
+			break
+		}
+
+		delete(inFlight.completed, inFlight.nextCommit)
+		inFlight.nextCommit++
+
+		// critical events extracted from the containers are sent by the fast path
+		for _, eventsContainer := range completedContainers {
+			eventsContainer.WaitCriticalSent()
+		}
 
 		// sync source state
-		gate.SystemState.Input <- eventsContainers
+		gate.SystemState.Input <- completedContainers
+		gate.Overload.Release(completedContainers)
 	}
 }
 
 func (gate HttpGateway) SendToServer(eventsContainers []*SecurityEventsContainer) {
 
-	CreateDirIfNotExist(".state", 0765)
+	CreateDirIfNotExist(SpoolDir, StateDirMode)
 
 	config := gate.MainConfig.Output
 
-	serverMessage := ServerRequestMessage{
-		AccessToken:        config.AccessToken,
+	// critical events failed before go ahead of the bulk spooled batches
+	gate._ResendCriticalSpool()
+
+	// check previous failed request and try to resend it, only one request at a time resends them
+	if atomic.CompareAndSwapInt32(&gate._inFlight.resending, 0, 1) {
+		gate._ResendSpool(gate._spool, gate._client)
+		atomic.StoreInt32(&gate._inFlight.resending, 0)
+	}
+
+	serverMessage := gate._NewMessage(eventsContainers)
+
+	for _, message := range gate._SplitMessage(serverMessage, config.Batch.MaxBytes) {
+		messageJson, _ := json.Marshal(message)
+
+		if gate._Deliver(gate._client, gate._spool, message, messageJson) {
+			gate._firstMessageSent = true
+
+			if len(message.Events) > 0 {
+				emit(logLevel.verbose, "Sent request on server. Body size: %d. Body: %s.", len(messageJson), messageJson)
+			}
+		} else if len(message.Events) > 0 {
+			// don't lose any security events, save it into temp file
+			gate._SpoolMessage(gate._spool, message)
+		}
+	}
+}
+
+// SendCriticalToServer sends critical events right away with the short timeout,
+// failed ones are spooled separately and resent before other spooled batches,
+// offsets of the sources of the events are committed only after the events are sent or spooled
+func (gate HttpGateway) SendCriticalToServer(eventsContainers []*SecurityEventsContainer) {
+
+	defer func() {
+		for _, eventsContainer := range eventsContainers {
+			eventsContainer.CriticalSent()
+		}
+	}()
+
+	CreateDirIfNotExist(SpoolDir, StateDirMode)
+
+	serverMessage := gate._NewMessage(eventsContainers)
+	if len(serverMessage.Events) < 1 {
+		return
+	}
+
+	messageJson, _ := json.Marshal(serverMessage)
+
+	if !gate._Deliver(gate._criticalClient, gate._criticalSpool, serverMessage, messageJson) {
+		emit(logLevel.important, "Failed sending %d critical events by the fast path, they will be sent again (batch: %s).\n", len(serverMessage.Events), serverMessage.BatchId)
+
+		err := gate._criticalSpool.Save(messageJson)
+		if err != nil {
+			emit(logLevel.important, "Failed to create tempfile in %s for writing: %s. %d events will be lost (batch: %s, sequence: %s).\n", gate._criticalSpool.Dir, err.Error(), len(serverMessage.Events), serverMessage.BatchId, serverMessage.SequenceRange())
+		}
+		return
+	}
+
+	emit(logLevel.verbose, "Sent critical events on server. Body size: %d. Body: %s.", len(messageJson), messageJson)
+
+	// the collector is available, deliver critical events failed before
+	gate._ResendCriticalSpool()
+}
+
+// _NewMessage builds the request with all events of the containers and reserves their sequence numbers
+func (gate HttpGateway) _NewMessage(eventsContainers []*SecurityEventsContainer) *ServerRequestMessage {
+
+	config := gate.MainConfig.Output
+
+	// the access token is added only to the request, so spooled messages never contain it
+	// batch id is stored in the spooled message, so a resent batch can be recognized by the server
+	serverMessage := &ServerRequestMessage{
+		BatchId:            NewUuid(),
 		ServerKey:          config.ServerKey,
 		LocalTimeUtcNumber: DateToCustomLong(time.Now()),
 	}
@@ -151,92 +336,208 @@ func (gate HttpGateway) SendToServer(eventsContainers []*SecurityEventsContainer
 		}
 	}
 
-	// check previous failed request and try to resend it
-	files, _ := filepath.Glob(".state/.net_*")
-	if len(files) > 0 {
-		sort.Strings(files)
-		for _, netfile := range files {
-			netContent, err := ioutil.ReadFile(netfile)
-			if err != nil {
-				emitLine(logLevel.important, "failed read net file %s, error: %s\n", netfile, err.Error())
-				continue
-			}
-
-			resp, errs := gate._client.Post(gate._serverUrl, "application/json", bytes.NewBuffer(netContent))
-
-			if errs == nil {
-				defer resp.Body.Close()
-				if resp.StatusCode == 200 {
-					err := os.Remove(netfile)
-					if err != nil {
-						emit(logLevel.important, "Failed removing net file %s, error: %s\n", netfile, err.Error())
-					}
-				}
+	if len(secEvents) > 0 {
+		firstSequence := gate.SystemState.ReserveSequence(uint64(len(secEvents)))
+		if firstSequence > 0 {
+			for i, secEvent := range secEvents {
+				secEvent.Sequence = firstSequence + uint64(i)
 			}
 		}
-	}
 
-	if len(secEvents) > 0 {
 		serverMessage.Events = secEvents
 	}
 
+	return serverMessage
+}
+
+// _SplitMessage halves the message until every part fits into maxBytes, a part with a single event is never split
+// every part gets own batch id, ip services are sent only with the first part
+func (gate HttpGateway) _SplitMessage(serverMessage *ServerRequestMessage, maxBytes int) []*ServerRequestMessage {
 	messageJson, _ := json.Marshal(serverMessage)
+	if len(messageJson) <= maxBytes || len(serverMessage.Events) < 2 {
+		return []*ServerRequestMessage{serverMessage}
+	}
+
+	middle := len(serverMessage.Events) / 2
 
-	resp, errs := gate._client.Post(gate._serverUrl, "application/json", bytes.NewBuffer([]byte(messageJson)))
+	first := *serverMessage
+	first.Events = serverMessage.Events[:middle]
 
-	//defer transport.CloseIdleConnections()
+	second := *serverMessage
+	second.BatchId = NewUuid()
+	second.Events = serverMessage.Events[middle:]
+	second.IpServices = nil
 
-	failed := false
+	emit(logLevel.verbose, "Split batch %s of %d bytes into %s and %s.", serverMessage.BatchId, len(messageJson), first.BatchId, second.BatchId)
+
+	return append(gate._SplitMessage(&first, maxBytes), gate._SplitMessage(&second, maxBytes)...)
+}
+
+// _ResendCriticalSpool resends critical events failed before, only one request at a time resends them
+func (gate HttpGateway) _ResendCriticalSpool() {
+	if atomic.CompareAndSwapInt32(&gate._inFlight.resendingCritical, 0, 1) {
+		gate._ResendSpool(gate._criticalSpool, gate._criticalClient)
+		atomic.StoreInt32(&gate._inFlight.resendingCritical, 0)
+	}
+}
+
+func (gate HttpGateway) _ResendSpool(spool *Spool, client *http.Client) {
+	for _, netfile := range spool.Files() {
+		netContent, err := ioutil.ReadFile(netfile)
+		if err != nil {
+			emitLine(logLevel.important, "failed read net file %s, error: %s\n", netfile, err.Error())
+			continue
+		}
+
+		netMessage := ServerRequestMessage{}
+		err = json.Unmarshal(netContent, &netMessage)
+		if err != nil {
+			emitLine(logLevel.important, "failed converting net file %s to json, error: %s", netfile, err.Error())
+			continue
+		}
+
+		if gate._Deliver(client, spool, &netMessage, netContent) {
+			spool.Remove(netfile)
+		}
+	}
+}
+
+// _Deliver sends the message and returns false if it should be sent again later,
+// events rejected by the collector temporarily are saved to the spool
+func (gate HttpGateway) _Deliver(client *http.Client, spool *Spool, serverMessage *ServerRequestMessage, messageJson []byte) bool {
+	resp, errs := gate._Post(client, messageJson)
 
 	if errs != nil {
-		errsJson, _ := json.Marshal(errs)
-		emit(logLevel.important, "Failed sending message to server. JsonSize: %d. Errors: %s.\n", len(messageJson), errsJson)
-		failed = true
-	} else {
-		defer resp.Body.Close()
-
-		if resp.StatusCode != 200 {
-			emit(logLevel.important, "Failed sending message to server. JsonSize: %d. Status Code: %d.\n", len(messageJson), resp.StatusCode)
-			failed = true
-		} else { // status 200, but body can contain error
-			response := ServerResponseMessage{}
-			body, _ := ioutil.ReadAll(resp.Body)
-			err := json.Unmarshal(body, &response)
-			if err != nil {
-				emit(logLevel.important, "Failed converting server response to json. Response: %s. \n", string(body))
-				failed = true
-			} else {
-				// successfully received response, check that success is true
-				if response.Success != true {
-					// if server send error code 1  (wrong json format, let loose all current events to prevent it in future
-					if response.ErrorCode == 1 {
-						emit(logLevel.important, "Failed sending requests to server. Server error: %s (%d). %d events will be lost. \n", response.ErrorMessage, response.ErrorCode, len(serverMessage.Events))
-						failed = false
-					} else {
-						emit(logLevel.important, "Failed sending requests to server. Server error: %s (%d). \n", response.ErrorMessage, response.ErrorCode)
-						failed = true
-					}
-				}
-			}
+		emit(logLevel.important, "Failed sending message to server. JsonSize: %d. Errors: %s.\n", len(messageJson), errs)
+		return false
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		emit(logLevel.important, "Failed sending message to server. JsonSize: %d. Status Code: %d.\n", len(messageJson), resp.StatusCode)
+		return false
+	}
+
+	// status 200, but body can contain error
+	response := ServerResponseMessage{}
+	body, _ := ioutil.ReadAll(resp.Body)
+	err := json.Unmarshal(body, &response)
+	if err != nil {
+		emit(logLevel.important, "Failed converting server response to json. Response: %s. \n", string(body))
+		return false
+	}
+
+	// server accepted the batch except the listed events
+	if len(response.Rejected) > 0 {
+		gate._ProcessRejectedEvents(spool, serverMessage, response.Rejected)
+		return true
+	}
+
+	// successfully received response, check that success is true
+	if response.Success != true {
+		// if server send error code 1  (wrong json format, let loose all current events to prevent it in future
+		if response.ErrorCode == 1 {
+			emit(logLevel.important, "Failed sending requests to server. Server error: %s (%d). %d events will be lost (batch: %s, sequence: %s). \n", response.ErrorMessage, response.ErrorCode, len(serverMessage.Events), serverMessage.BatchId, serverMessage.SequenceRange())
+			return true
 		}
+
+		emit(logLevel.important, "Failed sending requests to server. Server error: %s (%d). \n", response.ErrorMessage, response.ErrorCode)
+		return false
 	}
 
-	if failed == true {
-		// don't lose any security events, save it into temp file
-		if len(serverMessage.Events) > 0 {
+	return true
+}
 
-			publisherTmpFile := fmt.Sprintf(".state/.net_%d", time.Now().Unix())
-			tmpFileContent, _ := json.Marshal(serverMessage)
-			err := ioutil.WriteFile(publisherTmpFile, tmpFileContent, 0664)
-			if err != nil {
-				emit(logLevel.important, "Failed to create tempfile (%s) for writing: %s. Events will be lost.\n", publisherTmpFile, err.Error())
-			}
+// _ProcessRejectedEvents spools events rejected temporarily and moves invalid ones to the dead letter file
+func (gate HttpGateway) _ProcessRejectedEvents(spool *Spool, serverMessage *ServerRequestMessage, rejectedEvents []RejectedEvent) {
+	retryMessage := *serverMessage
+	retryMessage.BatchId = NewUuid()
+	retryMessage.Events = nil
+	retryMessage.IpServices = nil
+
+	for _, rejectedEvent := range rejectedEvents {
+		if rejectedEvent.Index < 0 || rejectedEvent.Index >= len(serverMessage.Events) {
+			emitLine(logLevel.important, "collector rejected unknown event index %d in batch %s: %s", rejectedEvent.Index, serverMessage.BatchId, rejectedEvent.Reason)
+			continue
 		}
-	} else {
-		gate._firstMessageSent = true
 
-		if len(serverMessage.Events) > 0 {
-			emit(logLevel.verbose, "Sent request on server. Body size: %d. Body: %s.", len(messageJson), messageJson)
+		secEvent := serverMessage.Events[rejectedEvent.Index]
+		ruleName := gate._ruleNamesBySid[secEvent.SecurityId]
+		source := ""
+		if secEvent.Source != nil {
+			source = *secEvent.Source
+		}
+
+		gatewayRejectedEvents.Add(fmt.Sprintf("%s/%d", ruleName, secEvent.SecurityId), 1)
+
+		if rejectedEvent.Retryable {
+			emitLine(logLevel.verbose, "collector rejected event sid %d (rule '%s', source '%s') temporarily: %s", secEvent.SecurityId, ruleName, source, rejectedEvent.Reason)
+			retryMessage.Events = append(retryMessage.Events, secEvent)
+			continue
+		}
+
+		emitLine(logLevel.important, "collector rejected event sid %d (rule '%s', source '%s'): %s. the event is moved to '%s'.", secEvent.SecurityId, ruleName, source, rejectedEvent.Reason, gate._deadLetter.Path)
+		gate._deadLetter.Write("gateway", rejectedEvent.Reason, NewEventRecord(secEvent, &SecurityEventsContainer{}, gate._host))
+	}
+
+	if len(retryMessage.Events) > 0 {
+		emitLine(logLevel.important, "collector rejected %d events of batch %s temporarily, they will be sent again.", len(retryMessage.Events), serverMessage.BatchId)
+		gate._SpoolMessage(spool, &retryMessage)
+	}
+}
+
+func (gate HttpGateway) _SpoolMessage(spool *Spool, serverMessage *ServerRequestMessage) {
+	content, _ := json.Marshal(serverMessage)
+	err := spool.Save(content)
+	if err != nil {
+		emit(logLevel.important, "Failed to create tempfile in %s for writing: %s. %d events will be lost (batch: %s, sequence: %s).\n", spool.Dir, err.Error(), len(serverMessage.Events), serverMessage.BatchId, serverMessage.SequenceRange())
+	}
+}
+
+// _Post sends the content to the first available collector, the next one is tried on connection or server errors
+func (gate HttpGateway) _Post(client *http.Client, content []byte) (*http.Response, error) {
+	body, err := gate._auth.Body(content)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := gate._endpoints.Ordered()
+
+	for i, endpoint := range endpoints {
+		request, err := http.NewRequest("POST", endpoint.Url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		gate._auth.Sign(request, body)
+
+		resp, err := client.Do(request)
+		if err == nil && resp.StatusCode < 500 {
+			gate._endpoints.ReportSuccess(endpoint)
+			return resp, nil
 		}
+
+		gate._endpoints.ReportFailure(endpoint)
+
+		if i == len(endpoints)-1 {
+			return resp, err
+		}
+
+		if err == nil {
+			resp.Body.Close()
+		}
+		emit(logLevel.verbose, "Collector %s is not available, trying %s.\n", endpoint.Url, endpoints[i+1].Url)
 	}
+
+	return nil, errors.New("collector url is not specified")
+}
+
+// SequenceRange returns the first and the last sequence numbers of the message events
+func (serverMessage *ServerRequestMessage) SequenceRange() string {
+	if len(serverMessage.Events) < 1 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%d-%d", serverMessage.Events[0].Sequence, serverMessage.Events[len(serverMessage.Events)-1].Sequence)
 }
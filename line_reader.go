@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"io"
+)
+
+// LineReader reads lines into a reused buffer and counts offset of the lines in bytes of the input,
+// so the offset is known without asking the file for its position
+type LineReader struct {
+	_reader    io.Reader
+	_buffer    []byte
+	_size      int
+	_maxLength int
+	_start     int
+	_end       int
+	_offset    int64
+	_err       error
+	_truncated bool
+}
+
+// NewLineReader returns the reader with the buffer of the size, lines longer than the max length are truncated,
+// so the buffer doesn't grow more than the max length with the size
+func NewLineReader(reader io.Reader, offset int64, size int, maxLength int) *LineReader {
+	return &LineReader{
+		_reader:    reader,
+		_buffer:    make([]byte, size),
+		_size:      size,
+		_maxLength: maxLength,
+		_offset:    offset,
+	}
+}
+
+// ReadLine returns the next line without \r\n, the line is valid only until the next call,
+// io.EOF is returned with the last line which is not terminated by the new line yet
+func (reader *LineReader) ReadLine() ([]byte, error) {
+	reader._truncated = false
+	searched := reader._start
+
+	for {
+		if i := bytes.IndexByte(reader._buffer[searched:reader._end], '\n'); i >= 0 {
+			end := searched + i
+			line := reader._buffer[reader._start:end]
+			reader._offset += int64(end + 1 - reader._start)
+			reader._start = end + 1
+
+			if len(line) > 0 && line[len(line)-1] == '\r' {
+				line = line[:len(line)-1]
+			}
+			return reader._Truncate(line), nil
+		}
+		if reader._err != nil {
+			line := reader._buffer[reader._start:reader._end]
+			reader._offset += int64(len(line))
+			reader._start = reader._end
+			return reader._Truncate(line), reader._err
+		}
+
+		// the line is longer than the max length also with \r
+		if reader._end-reader._start > reader._maxLength+1 {
+			return reader._SkipLine()
+		}
+
+		// the buffered part of the line is moved to the beginning of the buffer
+		searched = reader._end - reader._start
+		reader._Fill()
+	}
+}
+
+// Offset returns offset of the next line
+func (reader *LineReader) Offset() int64 {
+	return reader._offset
+}
+
+// Truncated returns true when the last read line is longer than the max length and only its beginning is returned
+func (reader *LineReader) Truncated() bool {
+	return reader._truncated
+}
+
+func (reader *LineReader) _Truncate(line []byte) []byte {
+	if len(line) > reader._maxLength {
+		reader._truncated = true
+		return line[:reader._maxLength]
+	}
+	return line
+}
+
+// _SkipLine returns the beginning of the line longer than the max length, the rest of the line is read after it
+// into the buffer and dropped until the new line
+func (reader *LineReader) _SkipLine() ([]byte, error) {
+	reader._Compact()
+	reader._truncated = true
+
+	lineEnd := reader._maxLength
+	if len(reader._buffer) < lineEnd+reader._size {
+		buffer := make([]byte, lineEnd+reader._size)
+		copy(buffer, reader._buffer[:reader._end])
+		reader._buffer = buffer
+	}
+
+	// the buffered rest of the line doesn't contain the new line
+	skipped := reader._end - lineEnd
+	reader._offset += int64(lineEnd)
+
+	for {
+		n, err := reader._reader.Read(reader._buffer[lineEnd:])
+
+		if i := bytes.IndexByte(reader._buffer[lineEnd:lineEnd+n], '\n'); i >= 0 {
+			// lines after the new line are kept in the buffer after the returned line
+			reader._offset += int64(skipped + i + 1)
+			reader._start = lineEnd + i + 1
+			reader._end = lineEnd + n
+			reader._err = err
+			return reader._buffer[:lineEnd], nil
+		}
+
+		skipped += n
+		if err != nil {
+			reader._offset += int64(skipped)
+			reader._start = lineEnd
+			reader._end = lineEnd
+			reader._err = err
+			return reader._buffer[:lineEnd], err
+		}
+	}
+}
+
+// _Compact moves the buffered line to the beginning of the buffer
+func (reader *LineReader) _Compact() {
+	if reader._start > 0 {
+		copy(reader._buffer, reader._buffer[reader._start:reader._end])
+		reader._end -= reader._start
+		reader._start = 0
+	}
+}
+
+// _Fill reads more data after the buffered line, the buffer is grown for lines longer than it
+func (reader *LineReader) _Fill() {
+	reader._Compact()
+
+	if reader._end == len(reader._buffer) {
+		size := 2 * len(reader._buffer)
+		if size > reader._maxLength+1+reader._size {
+			size = reader._maxLength + 1 + reader._size
+		}
+
+		buffer := make([]byte, size)
+		copy(buffer, reader._buffer[:reader._end])
+		reader._buffer = buffer
+	}
+
+	n, err := reader._reader.Read(reader._buffer[reader._end:])
+	reader._end += n
+	if err != nil {
+		reader._err = err
+	}
+}
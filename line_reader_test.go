@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+type lineReaderResult struct {
+	line   string
+	offset int64
+	err    error
+}
+
+func readAllLines(reader *LineReader) []lineReaderResult {
+	results := make([]lineReaderResult, 0)
+	for {
+		line, err := reader.ReadLine()
+		results = append(results, lineReaderResult{string(line), reader.Offset(), err})
+		if err != nil {
+			return results
+		}
+	}
+}
+
+func TestLineReader(t *testing.T) {
+	longLine := strings.Repeat("x", 200*1024)
+
+	tests := []struct {
+		name    string
+		content string
+		offset  int64
+		results []lineReaderResult
+	}{
+		{"empty", "", 0, []lineReaderResult{{"", 0, io.EOF}}},
+		{"lf", "a\nbc\n", 0, []lineReaderResult{{"a", 2, nil}, {"bc", 5, nil}, {"", 5, io.EOF}}},
+		// offsets count \r of the input
+		{"crlf", "a\r\nbc\r\n\r\n", 0, []lineReaderResult{{"a", 3, nil}, {"bc", 7, nil}, {"", 9, nil}, {"", 9, io.EOF}}},
+		{"lone cr", "a\rb\n", 0, []lineReaderResult{{"a\rb", 4, nil}, {"", 4, io.EOF}}},
+		// the last line is returned with io.EOF until it's terminated
+		{"unterminated", "a\nbc", 0, []lineReaderResult{{"a", 2, nil}, {"bc", 4, io.EOF}}},
+		{"unterminated cr", "a\nbc\r", 0, []lineReaderResult{{"a", 2, nil}, {"bc\r", 5, io.EOF}}},
+		{"offset", "a\nbc\n", 100, []lineReaderResult{{"a", 102, nil}, {"bc", 105, nil}, {"", 105, io.EOF}}},
+		// the buffer grows for lines longer than it
+		{"long line", "a\n" + longLine + "\nb\n", 0, []lineReaderResult{{"a", 2, nil}, {longLine, int64(len(longLine)) + 3, nil}, {"b", int64(len(longLine)) + 5, nil}, {"", int64(len(longLine)) + 5, io.EOF}}},
+		{"long unterminated", longLine, 0, []lineReaderResult{{longLine, int64(len(longLine)), io.EOF}}},
+	}
+
+	for _, test := range tests {
+		readers := map[string]func(io.Reader) io.Reader{
+			"buffered": func(reader io.Reader) io.Reader { return reader },
+			"one byte": iotest.OneByteReader,
+			"half":     iotest.HalfReader,
+		}
+
+		for readerName, newReader := range readers {
+			for _, size := range []int{1, 3, 64 * 1024} {
+				results := readAllLines(NewLineReader(newReader(strings.NewReader(test.content)), test.offset, size, 1024*1024))
+				if len(results) != len(test.results) {
+					t.Errorf("%s (%s, buffer %d): %d lines are read, expected %d", test.name, readerName, size, len(results), len(test.results))
+					continue
+				}
+
+				for i, result := range results {
+					if result != test.results[i] {
+						t.Errorf("%s (%s, buffer %d): line %d is %q at %d (%v), expected %q at %d (%v)", test.name, readerName, size, i,
+							shortLine(result.line), result.offset, result.err, shortLine(test.results[i].line), test.results[i].offset, test.results[i].err)
+					}
+				}
+			}
+		}
+	}
+}
+
+// the offset of the reader is the offset of the file, also when the file starts with the byte order mark
+func TestLineReaderFileOffsets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "line_reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"plain", "first\nsecond\r\nthird\n"},
+		{"bom", "\xEF\xBB\xBFfirst\nsecond\r\nthird\n"},
+		{"bom unterminated", "\xEF\xBB\xBFfirst\r\nsecond"},
+		{"bom only", "\xEF\xBB\xBF"},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(dir, strings.Replace(test.name, " ", "_", -1)+".log")
+		err := ioutil.WriteFile(path, []byte(test.content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decodingReader, err := Utf8Reader(file, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decodingReader != io.Reader(file) {
+			t.Errorf("%s: utf-8 file is decoded", test.name)
+		}
+
+		position, _ := file.Seek(0, io.SeekCurrent)
+		reader := NewLineReader(decodingReader, position, 4, 1024)
+
+		for {
+			lineStart := reader.Offset()
+			line, err := reader.ReadLine()
+
+			// the line is found in the file at its offset
+			if !bytes.HasPrefix([]byte(test.content[lineStart:]), line) {
+				t.Errorf("%s: line %q is not found at offset %d", test.name, line, lineStart)
+			}
+
+			if err != nil {
+				break
+			}
+		}
+
+		if reader.Offset() != int64(len(test.content)) {
+			t.Errorf("%s: offset at the end is %d, expected %d", test.name, reader.Offset(), len(test.content))
+		}
+		file.Close()
+	}
+}
+
+// lines longer than the max length are truncated, their rest is skipped and offsets of next lines are kept
+func TestLineReaderMaxLength(t *testing.T) {
+	type truncatedResult struct {
+		lineReaderResult
+		truncated bool
+	}
+
+	content := "ab\n" + strings.Repeat("x", 100) + "\n" + strings.Repeat("z", 20) + "\r\ncd\n" + "0123456789\r\n" + strings.Repeat("y", 50)
+	expected := []truncatedResult{
+		{lineReaderResult{"ab", 3, nil}, false},
+		{lineReaderResult{strings.Repeat("x", 10), 104, nil}, true},
+		{lineReaderResult{strings.Repeat("z", 10), 126, nil}, true},
+		{lineReaderResult{"cd", 129, nil}, false},
+		// the line of the max length isn't truncated
+		{lineReaderResult{"0123456789", 141, nil}, false},
+		// the unterminated line is truncated too, its rest is skipped until the end of the input
+		{lineReaderResult{strings.Repeat("y", 10), 191, io.EOF}, true},
+	}
+
+	readers := map[string]func(io.Reader) io.Reader{
+		"buffered": func(reader io.Reader) io.Reader { return reader },
+		"one byte": iotest.OneByteReader,
+		"half":     iotest.HalfReader,
+	}
+
+	for readerName, newReader := range readers {
+		for _, size := range []int{1, 3, 8, 64 * 1024} {
+			reader := NewLineReader(newReader(strings.NewReader(content)), 0, size, 10)
+
+			for i, result := range expected {
+				line, err := reader.ReadLine()
+				read := truncatedResult{lineReaderResult{string(line), reader.Offset(), err}, reader.Truncated()}
+				if read != result {
+					t.Errorf("%s, buffer %d: line %d is %q at %d (%v, truncated: %v), expected %q at %d (%v, truncated: %v)", readerName, size, i,
+						read.line, read.offset, read.err, read.truncated, result.line, result.offset, result.err, result.truncated)
+				}
+			}
+		}
+	}
+}
+
+// the buffer doesn't grow with the line when the input doesn't contain new lines
+func TestLineReaderLongInput(t *testing.T) {
+	input := io.LimitReader(bytes.NewReader(bytes.Repeat([]byte("x"), 64*1024*1024)), 64*1024*1024)
+	reader := NewLineReader(input, 0, 4096, 64*1024)
+
+	line, err := reader.ReadLine()
+	if len(line) != 64*1024 || err != io.EOF || !reader.Truncated() || reader.Offset() != 64*1024*1024 {
+		t.Errorf("line of %d bytes is read to offset %d (%v)", len(line), reader.Offset(), err)
+	}
+
+	if len(reader._buffer) > 64*1024+1+4096 {
+		t.Errorf("buffer is grown to %d bytes", len(reader._buffer))
+	}
+}
+
+func shortLine(line string) string {
+	if len(line) > 20 {
+		return line[:20] + "..."
+	}
+	return line
+}
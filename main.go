@@ -30,6 +30,11 @@ func main() {
 
 	program.Options = options
 
+	if len(options.Command) > 0 {
+		RunCommand(options, options.Command)
+		return
+	}
+
 	// Call svc.Run to start your program/service.
 	if err := svc.Run(program); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// emit reads options of the program, messages of the agent are not printed by tests
+	program = &Program{Options: testOptions()}
+	log.SetOutput(ioutil.Discard)
+
+	os.Exit(m.Run())
+}
+
+func testOptions() *Options {
+	return &Options{
+		ConfigDir:                "config",
+		IdleTimeout:              60 * time.Second,
+		DefaultFileDeadtime:      "360h",
+		DefaultExcludeFileFilter: "((.gz)|(.zip)|(.tar)|(.zip))",
+	}
+}
+
+// loadTestConfig loads the config with the shipped rules
+func loadTestConfig(t testing.TB) MainConfig {
+	config, err := LoadConfig(testOptions())
+	if err != nil {
+		t.Fatalf("failed loading config: %s", err)
+	}
+	return config
+}
+
+func findTestRule(t testing.TB, config MainConfig, name string) *RuleConfig {
+	for i := range config.Input.RuleConfigs {
+		if config.Input.RuleConfigs[i].RuleFileName == name {
+			return &config.Input.RuleConfigs[i]
+		}
+	}
+
+	t.Fatalf("rule '%s' is not loaded", name)
+	return nil
+}
+
+// generateAuthLog returns lines of auth.log, every few lines is an event of the sshd rule
+func generateAuthLog(count int) []string {
+	start := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	lines := make([]string, 0, count)
+
+	for i := 0; i < count; i++ {
+		timestamp := start.Add(time.Duration(i) * time.Second).Format(time.Stamp)
+
+		switch i % 8 {
+		case 0:
+			lines = append(lines, fmt.Sprintf("%s web1 sshd[%d]: Failed password for invalid user u%d from 10.0.%d.%d port %d ssh2", timestamp, 1000+i%5000, i, i%250, i%200, 1024+i%60000))
+		case 1:
+			lines = append(lines, fmt.Sprintf("%s web1 sshd[%d]: Accepted publickey for deploy from 192.168.%d.%d port %d ssh2: RSA SHA256:d1XxRm0V", timestamp, 1000+i%5000, i%250, i%200, 1024+i%60000))
+		case 2:
+			lines = append(lines, fmt.Sprintf("%s web1 sshd[%d]: Received disconnect from 10.1.%d.%d port %d:11: Bye Bye [preauth]", timestamp, 1000+i%5000, i%250, i%200, 1024+i%60000))
+		case 3:
+			lines = append(lines, fmt.Sprintf("%s web1 sshd[%d]: pam_unix(sshd:session): session closed for user deploy", timestamp, 1000+i%5000))
+		default:
+			lines = append(lines, fmt.Sprintf("%s web1 CRON[%d]: pam_unix(cron:session): session opened for user root by (uid=0)", timestamp, 2000+i%5000))
+		}
+	}
+
+	return lines
+}
+
+// generateAccessLog returns lines of apache access.log, every few lines is an event of the wordpress rule
+func generateAccessLog(count int) []string {
+	start := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	lines := make([]string, 0, count)
+
+	for i := 0; i < count; i++ {
+		timestamp := start.Add(time.Duration(i) * time.Second).Format("02/Jan/2006:15:04:05 -0700")
+		ip := fmt.Sprintf("203.0.%d.%d", i%250, i%200)
+
+		switch i % 6 {
+		case 0:
+			lines = append(lines, fmt.Sprintf(`%s - - [%s] "POST /wp-login.php HTTP/1.1" 302 1042 "-" "Mozilla/5.0"`, ip, timestamp))
+		case 1:
+			lines = append(lines, fmt.Sprintf(`%s - - [%s] "POST /wp-login.php HTTP/1.1" 200 4286 "-" "python-requests/2.31"`, ip, timestamp))
+		default:
+			lines = append(lines, fmt.Sprintf(`%s - - [%s] "GET /blog/page/%d/ HTTP/1.1" 200 %d "https://example.com/" "Mozilla/5.0"`, ip, timestamp, i%40, 5000+i%3000))
+		}
+	}
+
+	return lines
+}
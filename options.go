@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -19,6 +20,10 @@ type Options struct {
 	NetTimeout               int64
 	DefaultFileDeadtime      string
 	DefaultExcludeFileFilter string
+	StateDir                 string
+	SpoolDir                 string
+	// maintenance command and its arguments, the agent is not started when it's specified
+	Command []string
 }
 
 func (options *Options) ParseArguments() {
@@ -29,6 +34,8 @@ func (options *Options) ParseArguments() {
 
 	flag.StringVar(&options.ConfigDir, "config-dir", options.ConfigDir, "path to dhound-agent configuration directory")
 	flag.StringVar(&options.LogFile, "log-file", options.LogFile, "path to the dhound log file")
+	flag.StringVar(&options.StateDir, "state-dir", options.StateDir, "directory of the sources state (.state in the working directory by default)")
+	flag.StringVar(&options.SpoolDir, "spool-dir", options.SpoolDir, "directory of requests waiting to be sent again (the state directory by default)")
 
 	flag.IntVar(&options.IdleTimeoutInSeconds, "timeout", 60, "frequency in seconds to send data on the server")
 
@@ -37,8 +44,16 @@ func (options *Options) ParseArguments() {
 
 	flag.StringVar(&options.Pprof, "pprof", options.Pprof, "profiling option (for internal using)")
 
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] [command]\n\nOptions:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n%s", commandsUsage)
+	}
+
 	flag.Parse()
 
+	options.Command = flag.Args()
+
 	if runtime.GOOS == "windows" {
 		// for windows all files are located on the same folder, current directory should be set up in the code
 		execPath, err := Executable()
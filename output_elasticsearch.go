@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type ElasticsearchOutputConfig struct {
+	// base url of the cluster, for example https://opensearch.local:9200
+	Url string `json:"url" yaml:"url"`
+	// index name, a date placeholder in braces is replaced by the event date, for example dhound-{YYYY.MM.DD}
+	Index    string `json:"index,omitempty" yaml:"index,omitempty"`
+	Username string `json:"username,omitempty" yaml:"username,omitempty"`
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+	// api key in the encoded form (base64 of id:key)
+	ApiKey         string `json:"apikey,omitempty" yaml:"apikey,omitempty"`
+	CaFile         string `json:"cafile,omitempty" yaml:"cafile,omitempty"`
+	MaxEvents      int    `json:"maxevents,omitempty" yaml:"maxevents,omitempty"`
+	Retries        int    `json:"retries,omitempty" yaml:"retries,omitempty"`
+	Timeout        string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	DeadLetterFile string `json:"deadletterfile,omitempty" yaml:"deadletterfile,omitempty"`
+}
+
+// ElasticsearchOutput indexes security events in Elasticsearch/OpenSearch by the _bulk api
+type ElasticsearchOutput struct {
+	Config      *ElasticsearchOutputConfig
+	Host        *HostIdentity
+	_client     *http.Client
+	_bulkUrl    string
+	_deadLetter *DeadLetterFile
+}
+
+type elasticsearchDocument struct {
+	Timestamp string `json:"@timestamp"`
+	*EventRecord
+}
+
+type elasticsearchBulkItem struct {
+	index string
+	// the same event gets the same id, so the document is replaced when it's sent again
+	id       string
+	document []byte
+	record   *EventRecord
+}
+
+type elasticsearchBulkResponse struct {
+	Errors bool                                       `json:"errors"`
+	Items  []map[string]elasticsearchBulkItemResponse `json:"items"`
+}
+
+type elasticsearchBulkItemResponse struct {
+	Status int `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error,omitempty"`
+}
+
+// elasticsearchRejectedError is returned when the cluster rejects the whole request, so sending it again doesn't help
+type elasticsearchRejectedError struct {
+	message string
+}
+
+func (err *elasticsearchRejectedError) Error() string {
+	return err.message
+}
+
+var elasticsearchIndexDateRegex = regexp.MustCompile(`\{(.+?)\}`)
+
+// delay before the retry grows with every attempt
+var elasticsearchRetryDelay = 2 * time.Second
+
+func (output *ElasticsearchOutput) Name() string {
+	return "elasticsearch"
+}
+
+func (output *ElasticsearchOutput) Init() error {
+	config := output.Config
+
+	if len(config.Url) < 1 {
+		return errors.New("url is not specified")
+	}
+	output._bulkUrl = strings.TrimSuffix(config.Url, "/") + "/_bulk"
+
+	if len(config.Index) < 1 {
+		config.Index = "dhound-{YYYY.MM.DD}"
+	}
+	if config.MaxEvents <= 0 {
+		config.MaxEvents = 500
+	}
+	if config.Retries <= 0 {
+		config.Retries = 3
+	}
+	if len(config.DeadLetterFile) < 1 {
+		config.DeadLetterFile = filepath.Join(StateDir, "deadletter-elasticsearch.json")
+	}
+	output._deadLetter = &DeadLetterFile{Path: config.DeadLetterFile}
+
+	timeout := 30 * time.Second
+	if len(config.Timeout) > 0 {
+		var err error
+		timeout, err = time.ParseDuration(config.Timeout)
+		if err != nil {
+			return errors.New(fmt.Sprintf("incorrect timeout '%s': %s", config.Timeout, err))
+		}
+	}
+
+	tlsConfig := &tls.Config{}
+	if len(config.CaFile) > 0 {
+		pool, err := LoadCaBundle(config.CaFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	output._client = &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+			Proxy:           http.ProxyFromEnvironment,
+			IdleConnTimeout: 30 * time.Second,
+		},
+		Timeout: timeout,
+	}
+
+	return nil
+}
+
+func (output *ElasticsearchOutput) Send(eventsContainers []*SecurityEventsContainer) {
+	items := make([]*elasticsearchBulkItem, 0)
+
+	for _, eventsContainer := range eventsContainers {
+		if eventsContainer == nil {
+			continue
+		}
+
+		for _, securityEvent := range eventsContainer.SecurityEvents {
+			record := NewEventRecord(securityEvent, eventsContainer, output.Host)
+			document, err := json.Marshal(&elasticsearchDocument{Timestamp: record.Time, EventRecord: record})
+			if err != nil {
+				output._deadLetter.Write(output.Name(), err.Error(), record)
+				continue
+			}
+
+			items = append(items, &elasticsearchBulkItem{
+				index:    output.IndexName(CustomLongToTime(securityEvent.EventTimeUtcNumber)),
+				id:       ElasticsearchDocumentId(record),
+				document: document,
+				record:   record,
+			})
+		}
+	}
+
+	for len(items) > 0 {
+		size := output.Config.MaxEvents
+		if size > len(items) {
+			size = len(items)
+		}
+		output._SendBulk(items[:size])
+		items = items[size:]
+	}
+}
+
+// IndexName returns the index for the event date
+func (output *ElasticsearchOutput) IndexName(eventTime time.Time) string {
+	return elasticsearchIndexDateRegex.ReplaceAllStringFunc(output.Config.Index, func(placeholder string) string {
+		return eventTime.UTC().Format(replace(placeholder[1 : len(placeholder)-1]))
+	})
+}
+
+// ElasticsearchDocumentId returns id of the event built from the host, the line of the source, sid, time and message,
+// so the event found again after restart doesn't make a duplicate
+func ElasticsearchDocumentId(record *EventRecord) string {
+	hash := sha1.New()
+	for _, value := range []string{record.Host, record.ServerKey, record.Source, fmt.Sprintf("%d", record.Sid), record.Time, record.Message} {
+		hash.Write([]byte(value))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// _SendBulk sends the items and retries only the documents rejected with a temporary error,
+// requests rejected by the cluster are not retried
+func (output *ElasticsearchOutput) _SendBulk(items []*elasticsearchBulkItem) {
+	reason := ""
+
+	for attempt := 0; attempt <= output.Config.Retries && len(items) > 0; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * elasticsearchRetryDelay)
+		}
+
+		response, err := output._Post(items)
+		if _, rejected := err.(*elasticsearchRejectedError); rejected {
+			reason = err.Error()
+			emitLine(logLevel.important, "elasticsearch output: request of %d events is rejected. error: %s", len(items), err)
+			break
+		} else if err != nil {
+			reason = err.Error()
+			emitLine(logLevel.important, "elasticsearch output: failed sending %d events. attempt: %d. error: %s", len(items), attempt+1, err)
+			continue
+		}
+
+		if !response.Errors {
+			return
+		}
+
+		if len(response.Items) != len(items) {
+			reason = fmt.Sprintf("bulk response contains %d items instead of %d", len(response.Items), len(items))
+			emitLine(logLevel.important, "elasticsearch output: %s.", reason)
+			continue
+		}
+
+		rejectedItems := make([]*elasticsearchBulkItem, 0)
+		for i, responseItem := range response.Items {
+			for _, result := range responseItem {
+				if result.Status < 300 {
+					continue
+				}
+
+				itemReason := fmt.Sprintf("status %d", result.Status)
+				if result.Error != nil {
+					itemReason = fmt.Sprintf("status %d: %s: %s", result.Status, result.Error.Type, result.Error.Reason)
+				}
+
+				if result.Status == 429 || result.Status >= 500 {
+					reason = itemReason
+					rejectedItems = append(rejectedItems, items[i])
+				} else {
+					output._deadLetter.Write(output.Name(), itemReason, items[i].record)
+				}
+			}
+		}
+
+		if len(rejectedItems) > 0 {
+			emitLine(logLevel.important, "elasticsearch output: %d of %d events rejected temporarily. attempt: %d. reason: %s", len(rejectedItems), len(items), attempt+1, reason)
+		}
+		items = rejectedItems
+	}
+
+	if len(items) > 0 {
+		emitLine(logLevel.important, "elasticsearch output: %d events moved to the dead letter file '%s'.", len(items), output.Config.DeadLetterFile)
+		for _, item := range items {
+			output._deadLetter.Write(output.Name(), reason, item.record)
+		}
+	}
+}
+
+func (output *ElasticsearchOutput) _Post(items []*elasticsearchBulkItem) (*elasticsearchBulkResponse, error) {
+	var body bytes.Buffer
+	for _, item := range items {
+		action, _ := json.Marshal(map[string]map[string]string{"index": {"_index": item.index, "_id": item.id}})
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(item.document)
+		body.WriteByte('\n')
+	}
+
+	request, err := http.NewRequest("POST", output._bulkUrl, &body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-ndjson")
+
+	if len(output.Config.ApiKey) > 0 {
+		request.Header.Set("Authorization", "ApiKey "+output.Config.ApiKey)
+	} else if len(output.Config.Username) > 0 {
+		request.SetBasicAuth(output.Config.Username, output.Config.Password)
+	}
+
+	resp, err := output._client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, _ := ioutil.ReadAll(resp.Body)
+
+	// the request itself is wrong, like invalid data or credentials, only timeouts and throttling of 4xx are temporary
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != 408 && resp.StatusCode != 429 {
+		return nil, &elasticsearchRejectedError{message: fmt.Sprintf("status code %d: %s", resp.StatusCode, content)}
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New(fmt.Sprintf("status code %d: %s", resp.StatusCode, content))
+	}
+
+	response := &elasticsearchBulkResponse{}
+	err = json.Unmarshal(content, response)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed parsing bulk response: %s", err))
+	}
+
+	return response, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBulkServer answers bulk requests by the handler of the request number and records ids of the sent documents
+type testBulkServer struct {
+	*httptest.Server
+	_mutex    sync.Mutex
+	_requests [][]string
+}
+
+func newTestBulkServer(t *testing.T, handler func(request int, ids []string, writer http.ResponseWriter)) *testBulkServer {
+	server := &testBulkServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ids := make([]string, 0)
+		scanner := bufio.NewScanner(request.Body)
+		for line := 0; scanner.Scan(); line++ {
+			// actions and documents are sent by turns
+			if line%2 == 0 {
+				var action map[string]map[string]string
+				if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+					t.Errorf("bulk action %q is not json: %s", scanner.Text(), err)
+				}
+				ids = append(ids, action["index"]["_id"])
+			}
+		}
+
+		server._mutex.Lock()
+		server._requests = append(server._requests, ids)
+		number := len(server._requests)
+		server._mutex.Unlock()
+
+		handler(number, ids, writer)
+	}))
+	return server
+}
+
+func (server *testBulkServer) Requests() [][]string {
+	server._mutex.Lock()
+	defer server._mutex.Unlock()
+	return server._requests
+}
+
+// bulkResponse returns the bulk response with statuses of the items
+func bulkResponse(statuses ...int) string {
+	response := elasticsearchBulkResponse{}
+	for _, status := range statuses {
+		item := elasticsearchBulkItemResponse{Status: status}
+		if status >= 300 {
+			response.Errors = true
+			item.Error = &struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			}{"test_exception", "rejected by test"}
+		}
+		response.Items = append(response.Items, map[string]elasticsearchBulkItemResponse{"index": item})
+	}
+	content, _ := json.Marshal(response)
+	return string(content)
+}
+
+func newTestElasticsearchOutput(t *testing.T, url string, dir string) *ElasticsearchOutput {
+	output := &ElasticsearchOutput{
+		Config: &ElasticsearchOutputConfig{Url: url, Retries: 2, DeadLetterFile: filepath.Join(dir, "deadletter.json")},
+		Host:   &HostIdentity{Hostname: "web1"},
+	}
+	if err := output.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return output
+}
+
+func testElasticsearchEvents() []*SecurityEventsContainer {
+	eventsContainer := &SecurityEventsContainer{Source: "/var/log/auth.log"}
+	for _, sid := range []uint{10002, 10003, 10004} {
+		eventsContainer.SecurityEvents = append(eventsContainer.SecurityEvents, &SecurityEvent{SecurityId: sid, EventTimeUtcNumber: 20260303101542, IpAddress: "203.0.113.5"})
+	}
+	return []*SecurityEventsContainer{eventsContainer}
+}
+
+// readDeadLetters returns sids and reasons of the dead letter records
+func readDeadLetters(t *testing.T, path string) map[uint]string {
+	records := make(map[uint]string)
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return records
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var record DeadLetterRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("dead letter record %q is not json: %s", line, err)
+		}
+		records[record.Event.Sid] = record.Reason
+	}
+	return records
+}
+
+func TestElasticsearchOutputPartialFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elasticsearch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	retryDelay := elasticsearchRetryDelay
+	elasticsearchRetryDelay = time.Millisecond
+	defer func() { elasticsearchRetryDelay = retryDelay }()
+
+	tests := []struct {
+		name     string
+		handler  func(request int, ids []string, writer http.ResponseWriter)
+		requests []int
+		// index of the document in the first request which is sent again
+		retried     int
+		deadLetters map[uint]string
+	}{
+		// only the document throttled by the cluster is sent again, the invalid one isn't retried
+		{"partial failure", func(request int, ids []string, writer http.ResponseWriter) {
+			if request == 1 {
+				writer.Write([]byte(bulkResponse(201, 429, 400)))
+			} else {
+				writer.Write([]byte(bulkResponse(201)))
+			}
+		}, []int{3, 1}, 1, map[uint]string{10004: "status 400: test_exception: rejected by test"}},
+		{"retries exhausted", func(request int, ids []string, writer http.ResponseWriter) {
+			if request == 1 {
+				writer.Write([]byte(bulkResponse(201, 201, 503)))
+			} else {
+				writer.Write([]byte(bulkResponse(503)))
+			}
+		}, []int{3, 1, 1}, 2, map[uint]string{10004: "status 503: test_exception: rejected by test"}},
+		{"server error", func(request int, ids []string, writer http.ResponseWriter) {
+			if request == 1 {
+				writer.WriteHeader(502)
+				return
+			}
+			writer.Write([]byte(bulkResponse(201, 201, 201)))
+		}, []int{3, 3}, -1, map[uint]string{}},
+		// the request rejected by the cluster is not sent again
+		{"rejected request", func(request int, ids []string, writer http.ResponseWriter) {
+			writer.WriteHeader(401)
+			writer.Write([]byte("unauthorized"))
+		}, []int{3}, -1, map[uint]string{10002: "status code 401: unauthorized", 10003: "status code 401: unauthorized", 10004: "status code 401: unauthorized"}},
+	}
+
+	for i, test := range tests {
+		server := newTestBulkServer(t, test.handler)
+		output := newTestElasticsearchOutput(t, server.URL, filepath.Join(dir, Itoa(i)))
+		output.Send(testElasticsearchEvents())
+		server.Close()
+
+		requests := server.Requests()
+		counts := make([]int, len(requests))
+		for j, ids := range requests {
+			counts[j] = len(ids)
+		}
+		if !reflect.DeepEqual(counts, test.requests) {
+			t.Errorf("%s: requests have %v documents, expected %v", test.name, counts, test.requests)
+			continue
+		}
+
+		// the document is sent again with the same id, so it isn't duplicated
+		if test.retried >= 0 {
+			for _, ids := range requests[1:] {
+				if ids[0] != requests[0][test.retried] {
+					t.Errorf("%s: retried document has id %s, expected %s", test.name, ids[0], requests[0][test.retried])
+				}
+			}
+		}
+
+		deadLetters := readDeadLetters(t, output.Config.DeadLetterFile)
+		if !reflect.DeepEqual(deadLetters, test.deadLetters) {
+			t.Errorf("%s: dead letters are %v, expected %v", test.name, deadLetters, test.deadLetters)
+		}
+	}
+}
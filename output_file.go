@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+type FileOutputConfig struct {
+	// path to the file or 'stdout'
+	Path       string `json:"path" yaml:"path"`
+	MaxSize    int    `json:"maxsize,omitempty" yaml:"maxsize,omitempty"`
+	MaxBackups int    `json:"maxbackups,omitempty" yaml:"maxbackups,omitempty"`
+	MaxAge     int    `json:"maxage,omitempty" yaml:"maxage,omitempty"`
+}
+
+// FileOutput writes security events as json lines (NDJSON) to stdout or to a rotated file
+type FileOutput struct {
+	Config  *FileOutputConfig
+	Host    *HostIdentity
+	_writer io.Writer
+}
+
+func (output *FileOutput) Name() string {
+	return "file"
+}
+
+func (output *FileOutput) Init() error {
+	path := output.Config.Path
+	if len(path) < 1 {
+		return errors.New("path is not specified")
+	}
+
+	if path == "stdout" || path == "-" {
+		output._writer = os.Stdout
+		return nil
+	}
+
+	err := CreateDirIfNotExist(filepath.Dir(path), 0765)
+	if err != nil {
+		return err
+	}
+
+	output._writer = NewRotatingFile(path, output.Config.MaxSize, output.Config.MaxBackups, output.Config.MaxAge)
+	return nil
+}
+
+func (output *FileOutput) Send(eventsContainers []*SecurityEventsContainer) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+
+	for _, eventsContainer := range eventsContainers {
+		if eventsContainer == nil {
+			continue
+		}
+
+		for _, securityEvent := range eventsContainer.SecurityEvents {
+			err := encoder.Encode(NewEventRecord(securityEvent, eventsContainer, output.Host))
+			if err != nil {
+				emitLine(logLevel.important, "file output: failed converting event %d to json. error: %s", securityEvent.SecurityId, err)
+			}
+		}
+	}
+
+	if buffer.Len() < 1 {
+		return
+	}
+
+	_, err := output._writer.Write(buffer.Bytes())
+	if err != nil {
+		emitLine(logLevel.important, "file output: failed writing events to '%s'. error: %s", output.Config.Path, err)
+	}
+}
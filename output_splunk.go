@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type SplunkOutputConfig struct {
+	// base url of HTTP Event Collector, for example https://splunk.local:8088
+	Url        string `json:"url" yaml:"url"`
+	Token      string `json:"token" yaml:"token"`
+	SourceType string `json:"sourcetype,omitempty" yaml:"sourcetype,omitempty"`
+	Index      string `json:"index,omitempty" yaml:"index,omitempty"`
+	Host       string `json:"host,omitempty" yaml:"host,omitempty"`
+	// wait for indexer acknowledgement (must be enabled for the token in splunk)
+	Ack        bool   `json:"ack,omitempty" yaml:"ack,omitempty"`
+	AckTimeout string `json:"acktimeout,omitempty" yaml:"acktimeout,omitempty"`
+	CaFile     string `json:"cafile,omitempty" yaml:"cafile,omitempty"`
+	// events of requests rejected by the collector are moved to the file instead of being sent again
+	DeadLetterFile string `json:"deadletterfile,omitempty" yaml:"deadletterfile,omitempty"`
+}
+
+// SplunkOutput posts security events to Splunk HTTP Event Collector, failed requests are spooled and resent later
+type SplunkOutput struct {
+	Config      *SplunkOutputConfig
+	Host        *HostIdentity
+	_client     *http.Client
+	_eventUrl   string
+	_ackUrl     string
+	_channel    string
+	_ackTimeout time.Duration
+	_spool      *Spool
+	_deadLetter *DeadLetterFile
+}
+
+type splunkEvent struct {
+	Time       int64        `json:"time"`
+	Host       string       `json:"host,omitempty"`
+	Source     string       `json:"source,omitempty"`
+	SourceType string       `json:"sourcetype,omitempty"`
+	Index      string       `json:"index,omitempty"`
+	Event      *EventRecord `json:"event"`
+}
+
+type splunkResponse struct {
+	Text  string `json:"text"`
+	Code  int    `json:"code"`
+	AckId *int64 `json:"ackId,omitempty"`
+}
+
+type splunkAckResponse struct {
+	Acks map[string]bool `json:"acks"`
+}
+
+// delay before the first poll of the acknowledgement, it's doubled after every poll
+var splunkAckDelay = time.Second
+
+// splunkRejectedError is returned when the collector rejects the request itself, so sending it again doesn't help
+type splunkRejectedError struct {
+	message string
+}
+
+func (err *splunkRejectedError) Error() string {
+	return err.message
+}
+
+func (output *SplunkOutput) Name() string {
+	return "splunk"
+}
+
+func (output *SplunkOutput) Init() error {
+	config := output.Config
+
+	if len(config.Url) < 1 {
+		return errors.New("url is not specified")
+	}
+	if len(config.Token) < 1 {
+		return errors.New("token is not specified")
+	}
+
+	baseUrl := strings.TrimSuffix(config.Url, "/")
+	output._eventUrl = baseUrl + "/services/collector/event"
+	output._ackUrl = baseUrl + "/services/collector/ack"
+	output._channel = NewUuid()
+	output._spool = &Spool{Dir: SpoolDir, Prefix: ".splunk_"}
+
+	if len(config.DeadLetterFile) < 1 {
+		config.DeadLetterFile = filepath.Join(StateDir, "deadletter-splunk.json")
+	}
+	output._deadLetter = &DeadLetterFile{Path: config.DeadLetterFile}
+
+	if len(config.SourceType) < 1 {
+		config.SourceType = "dhound:security"
+	}
+	if len(config.Host) < 1 {
+		config.Host = output.Host.Hostname
+	}
+
+	output._ackTimeout = 60 * time.Second
+	if len(config.AckTimeout) > 0 {
+		var err error
+		output._ackTimeout, err = time.ParseDuration(config.AckTimeout)
+		if err != nil {
+			return errors.New(fmt.Sprintf("incorrect acktimeout '%s': %s", config.AckTimeout, err))
+		}
+	}
+
+	tlsConfig := &tls.Config{}
+	if len(config.CaFile) > 0 {
+		pool, err := LoadCaBundle(config.CaFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	output._client = &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+			Proxy:           http.ProxyFromEnvironment,
+			IdleConnTimeout: 30 * time.Second,
+		},
+		Timeout: 30 * time.Second,
+	}
+
+	return nil
+}
+
+func (output *SplunkOutput) Send(eventsContainers []*SecurityEventsContainer) {
+
+	// resend previously failed requests first, stop on the first failure as splunk is still not available,
+	// requests rejected by splunk are moved to the dead letter file
+	for _, spoolFile := range output._spool.Files() {
+		content, err := ioutil.ReadFile(spoolFile)
+		if err != nil {
+			emitLine(logLevel.important, "splunk output: failed read spool file %s, error: %s", spoolFile, err)
+			continue
+		}
+
+		err = output._Post(content)
+		if rejectedErr, rejected := err.(*splunkRejectedError); rejected {
+			output._MoveToDeadLetter(content, rejectedErr)
+		} else if err != nil {
+			break
+		}
+		output._spool.Remove(spoolFile)
+	}
+
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	total := 0
+	for _, eventsContainer := range eventsContainers {
+		if eventsContainer == nil {
+			continue
+		}
+
+		for _, securityEvent := range eventsContainer.SecurityEvents {
+			record := NewEventRecord(securityEvent, eventsContainer, output.Host)
+			err := encoder.Encode(&splunkEvent{
+				Time:       securityEvent.EventTimeUtcNumber,
+				Host:       output.Config.Host,
+				Source:     record.Source,
+				SourceType: output.Config.SourceType,
+				Index:      output.Config.Index,
+				Event:      record,
+			})
+			if err != nil {
+				emitLine(logLevel.important, "splunk output: failed converting event %d to json. error: %s", securityEvent.SecurityId, err)
+				continue
+			}
+			total++
+		}
+	}
+
+	if total < 1 {
+		return
+	}
+
+	err := output._Post(body.Bytes())
+	if rejectedErr, rejected := err.(*splunkRejectedError); rejected {
+		output._MoveToDeadLetter(body.Bytes(), rejectedErr)
+	} else if err != nil {
+		emitLine(logLevel.important, "splunk output: failed sending %d events. error: %s", total, err)
+
+		// don't lose any security events, save them to send later
+		err = output._spool.Save(body.Bytes())
+		if err != nil {
+			emitLine(logLevel.important, "splunk output: failed to spool request: %s. %d events will be lost.", err, total)
+		}
+	}
+}
+
+// _MoveToDeadLetter writes events of the rejected request to the dead letter file
+func (output *SplunkOutput) _MoveToDeadLetter(body []byte, rejectedErr *splunkRejectedError) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	moved := 0
+	for {
+		event := &splunkEvent{}
+		err := decoder.Decode(event)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			emitLine(logLevel.important, "splunk output: failed parsing rejected request, %d events are moved to the dead letter file. error: %s", moved, err)
+			return
+		}
+
+		if event.Event != nil {
+			output._deadLetter.Write(output.Name(), rejectedErr.Error(), event.Event)
+			moved++
+		}
+	}
+
+	emitLine(logLevel.important, "splunk output: request is rejected: %s. %d events are moved to the dead letter file '%s'.", rejectedErr, moved, output.Config.DeadLetterFile)
+}
+
+func (output *SplunkOutput) _Post(body []byte) error {
+	content, err := output._Request(output._eventUrl, body)
+	if err != nil {
+		return err
+	}
+
+	response := &splunkResponse{}
+	err = json.Unmarshal(content, response)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed parsing response: %s", content))
+	}
+
+	if response.Code != 0 {
+		return errors.New(fmt.Sprintf("%s (%d)", response.Text, response.Code))
+	}
+
+	if output.Config.Ack {
+		if response.AckId == nil {
+			return errors.New("acknowledgement is not enabled for the token")
+		}
+		return output._WaitAck(*response.AckId)
+	}
+
+	return nil
+}
+
+// _WaitAck polls the collector until the indexer confirms the request is written
+func (output *SplunkOutput) _WaitAck(ackId int64) error {
+	request, _ := json.Marshal(map[string][]int64{"acks": {ackId}})
+	deadline := time.Now().Add(output._ackTimeout)
+
+	for delay := splunkAckDelay; time.Now().Before(deadline); delay *= 2 {
+		time.Sleep(delay)
+
+		content, err := output._Request(output._ackUrl, request)
+		if err != nil {
+			continue
+		}
+
+		response := &splunkAckResponse{}
+		if json.Unmarshal(content, response) == nil && response.Acks[I64toa(ackId)] {
+			return nil
+		}
+	}
+
+	return errors.New(fmt.Sprintf("indexer acknowledgement %d is not received in %s", ackId, output._ackTimeout))
+}
+
+func (output *SplunkOutput) _Request(url string, body []byte) ([]byte, error) {
+	request, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Splunk "+output.Config.Token)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Splunk-Request-Channel", output._channel)
+
+	resp, err := output._client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, _ := ioutil.ReadAll(resp.Body)
+
+	// the request itself is wrong, like invalid data or token, only timeouts and throttling of 4xx are temporary
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != 408 && resp.StatusCode != 429 {
+		return nil, &splunkRejectedError{message: fmt.Sprintf("status code %d: %s", resp.StatusCode, content)}
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New(fmt.Sprintf("status code %d: %s", resp.StatusCode, content))
+	}
+
+	return content, nil
+}
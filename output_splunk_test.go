@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSplunkServer accepts events by the status of the collector and acknowledges them when acks are enabled,
+// sids of accepted requests are recorded in the order of sending
+type testSplunkServer struct {
+	*httptest.Server
+	_mutex    sync.Mutex
+	_status   int
+	_ack      bool
+	_accepted [][]uint
+	_pending  map[int64][]uint
+	_ackId    int64
+}
+
+func newTestSplunkServer(t *testing.T) *testSplunkServer {
+	server := &testSplunkServer{_status: 200, _ack: true, _pending: make(map[int64][]uint)}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Authorization") != "Splunk test-token" {
+			t.Errorf("request is authorized by '%s'", request.Header.Get("Authorization"))
+		}
+
+		server._mutex.Lock()
+		defer server._mutex.Unlock()
+
+		if request.URL.Path == "/services/collector/ack" {
+			var ackRequest map[string][]int64
+			json.NewDecoder(request.Body).Decode(&ackRequest)
+
+			acks := make(map[string]bool)
+			for _, ackId := range ackRequest["acks"] {
+				acks[I64toa(ackId)] = server._ack
+				if server._ack && server._pending[ackId] != nil {
+					server._accepted = append(server._accepted, server._pending[ackId])
+					delete(server._pending, ackId)
+				}
+			}
+			json.NewEncoder(writer).Encode(&splunkAckResponse{Acks: acks})
+			return
+		}
+
+		if server._status != 200 {
+			writer.WriteHeader(server._status)
+			writer.Write([]byte(`{"text":"test error","code":6}`))
+			return
+		}
+
+		sids := make([]uint, 0)
+		scanner := bufio.NewScanner(request.Body)
+		for scanner.Scan() {
+			var event splunkEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				t.Errorf("event %q is not json: %s", scanner.Text(), err)
+				continue
+			}
+			sids = append(sids, event.Event.Sid)
+		}
+
+		server._ackId++
+		server._pending[server._ackId] = sids
+		fmt.Fprintf(writer, `{"text":"Success","code":0,"ackId":%d}`, server._ackId)
+	}))
+	return server
+}
+
+func (server *testSplunkServer) Set(status int, ack bool) {
+	server._mutex.Lock()
+	defer server._mutex.Unlock()
+	server._status = status
+	server._ack = ack
+}
+
+func (server *testSplunkServer) Accepted() [][]uint {
+	server._mutex.Lock()
+	defer server._mutex.Unlock()
+	return server._accepted
+}
+
+func testSplunkEvents(sids ...uint) []*SecurityEventsContainer {
+	eventsContainer := &SecurityEventsContainer{Source: "/var/log/auth.log"}
+	for _, sid := range sids {
+		eventsContainer.SecurityEvents = append(eventsContainer.SecurityEvents, &SecurityEvent{SecurityId: sid, EventTimeUtcNumber: 20260303101542, IpAddress: "203.0.113.5"})
+	}
+	return []*SecurityEventsContainer{eventsContainer}
+}
+
+// requests not acknowledged in time are spooled and sent again before new events, requests rejected at replay
+// are moved to the dead letter file
+func TestSplunkOutputAckSpool(t *testing.T) {
+	dir, restore := useTestStateDir(t)
+	defer restore()
+
+	ackDelay := splunkAckDelay
+	splunkAckDelay = 5 * time.Millisecond
+	defer func() { splunkAckDelay = ackDelay }()
+
+	server := newTestSplunkServer(t)
+	defer server.Close()
+
+	output := &SplunkOutput{
+		Config: &SplunkOutputConfig{Url: server.URL, Token: "test-token", Ack: true, AckTimeout: "50ms"},
+		Host:   &HostIdentity{Hostname: "web1"},
+	}
+	if err := output.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Set(200, false)
+	output.Send(testSplunkEvents(10001, 10002))
+	if files := output._spool.Files(); len(files) != 1 {
+		t.Fatalf("%d spool files after the ack timeout, expected 1", len(files))
+	}
+
+	// the collector is not available, the new request is spooled after the old one
+	server.Set(503, true)
+	output.Send(testSplunkEvents(10003))
+	if files := output._spool.Files(); len(files) != 2 {
+		t.Fatalf("%d spool files while the collector is not available, expected 2", len(files))
+	}
+
+	server.Set(200, true)
+	output.Send(testSplunkEvents(10004))
+	if files := output._spool.Files(); len(files) != 0 {
+		t.Errorf("%d spool files after replay, expected 0", len(files))
+	}
+
+	// the first request isn't acknowledged, so it's indexed again, it's replayed before the later ones
+	expected := [][]uint{{10001, 10002}, {10003}, {10004}}
+	if !reflect.DeepEqual(server.Accepted(), expected) {
+		t.Errorf("accepted requests are %v, expected %v", server.Accepted(), expected)
+	}
+
+	// the spooled request rejected by the collector isn't sent again
+	server.Set(503, true)
+	output.Send(testSplunkEvents(10005))
+	server.Set(400, true)
+	output.Send(testSplunkEvents(10006))
+
+	if files := output._spool.Files(); len(files) != 0 {
+		t.Errorf("%d spool files after rejection, expected 0", len(files))
+	}
+	deadLetters := readDeadLetters(t, filepath.Join(dir, "deadletter-splunk.json"))
+	if len(deadLetters) != 2 || len(deadLetters[10005]) < 1 || len(deadLetters[10006]) < 1 {
+		t.Errorf("dead letters are %v, expected events 10005 and 10006", deadLetters)
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+type SyslogOutputConfig struct {
+	// host:port of the syslog receiver
+	Address string `json:"address" yaml:"address"`
+	// udp (default), tcp or tls
+	Protocol string `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	// cef (default) or leef
+	Format string `json:"format,omitempty" yaml:"format,omitempty"`
+	// tcp/tls framing: octetcounting (default, RFC6587) or newline
+	Framing  string `json:"framing,omitempty" yaml:"framing,omitempty"`
+	Facility int    `json:"facility,omitempty" yaml:"facility,omitempty"`
+	AppName  string `json:"appname,omitempty" yaml:"appname,omitempty"`
+	// tls only: CA bundle to verify the receiver and expected server name
+	CaFile     string `json:"cafile,omitempty" yaml:"cafile,omitempty"`
+	ServerName string `json:"servername,omitempty" yaml:"servername,omitempty"`
+}
+
+// SyslogOutput sends security events in CEF or LEEF format over syslog (RFC5424) to SIEM systems
+type SyslogOutput struct {
+	Config     *SyslogOutputConfig
+	Host       *HostIdentity
+	_tlsConfig *tls.Config
+	_conn      net.Conn
+}
+
+const (
+	syslogDefaultFacility = 10 // security/authorization messages
+	syslogSeverityCrit    = 2
+	syslogSeverityWarning = 4
+	syslogWriteTimeout    = 10 * time.Second
+
+	siemVendor  = "DHound"
+	siemProduct = "dhound-agent"
+)
+
+// cef and leef dictionary names for the common fields of the rules
+var cefFieldNames = map[string]string{
+	"user": "suser",
+	"port": "dpt",
+	"site": "dhost",
+}
+
+var leefFieldNames = map[string]string{
+	"user": "usrName",
+	"port": "dstPort",
+	"site": "dstHost",
+}
+
+func (output *SyslogOutput) Name() string {
+	return "syslog"
+}
+
+func (output *SyslogOutput) Init() error {
+	config := output.Config
+
+	if len(config.Address) < 1 {
+		return errors.New("address is not specified")
+	}
+
+	config.Protocol = strings.ToLower(config.Protocol)
+	if config.Protocol == "" {
+		config.Protocol = "udp"
+	}
+	if config.Protocol != "udp" && config.Protocol != "tcp" && config.Protocol != "tls" {
+		return errors.New(fmt.Sprintf("protocol '%s' is not supported, use udp, tcp or tls", config.Protocol))
+	}
+
+	config.Format = strings.ToLower(config.Format)
+	if config.Format == "" {
+		config.Format = "cef"
+	}
+	if config.Format != "cef" && config.Format != "leef" {
+		return errors.New(fmt.Sprintf("format '%s' is not supported, use cef or leef", config.Format))
+	}
+
+	config.Framing = strings.ToLower(config.Framing)
+	if config.Framing == "" {
+		config.Framing = "octetcounting"
+	}
+	if config.Framing != "octetcounting" && config.Framing != "newline" {
+		return errors.New(fmt.Sprintf("framing '%s' is not supported, use octetcounting or newline", config.Framing))
+	}
+
+	if config.Facility <= 0 || config.Facility > 23 {
+		config.Facility = syslogDefaultFacility
+	}
+
+	if len(config.AppName) < 1 {
+		config.AppName = siemProduct
+	}
+
+	if config.Protocol == "tls" {
+		output._tlsConfig = &tls.Config{ServerName: config.ServerName}
+		if len(config.CaFile) > 0 {
+			pool, err := LoadCaBundle(config.CaFile)
+			if err != nil {
+				return err
+			}
+			output._tlsConfig.RootCAs = pool
+		}
+	}
+
+	return nil
+}
+
+func (output *SyslogOutput) Send(eventsContainers []*SecurityEventsContainer) {
+	messages := make([][]byte, 0)
+	for _, eventsContainer := range eventsContainers {
+		if eventsContainer == nil {
+			continue
+		}
+
+		for _, securityEvent := range eventsContainer.SecurityEvents {
+			messages = append(messages, output.FormatMessage(securityEvent, eventsContainer))
+		}
+	}
+
+	for i, message := range messages {
+		err := output._Write(message)
+		if err != nil {
+			// reconnect once, the receiver could close an idle connection
+			output._Close()
+			err = output._Write(message)
+		}
+
+		if err != nil {
+			output._Close()
+			emitLine(logLevel.important, "syslog output: failed sending to '%s'. error: %s. %d events will be lost.", output.Config.Address, err, len(messages)-i)
+			return
+		}
+	}
+}
+
+// FormatMessage returns a syslog RFC5424 message with the security event in CEF or LEEF format
+func (output *SyslogOutput) FormatMessage(securityEvent *SecurityEvent, eventsContainer *SecurityEventsContainer) []byte {
+	record := NewEventRecord(securityEvent, eventsContainer, output.Host)
+
+	severity := syslogSeverityWarning
+	if securityEvent.Critical {
+		severity = syslogSeverityCrit
+	}
+
+	var body string
+	if output.Config.Format == "leef" {
+		body = FormatLeef(securityEvent, record)
+	} else {
+		body = FormatCef(securityEvent, record)
+	}
+
+	// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s - %d - %s",
+		output.Config.Facility*8+severity,
+		time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+		syslogHeaderValue(record.Host),
+		syslogHeaderValue(output.Config.AppName),
+		securityEvent.SecurityId,
+		body))
+}
+
+func (output *SyslogOutput) _Write(message []byte) error {
+	if output._conn == nil {
+		conn, err := output._Dial()
+		if err != nil {
+			return err
+		}
+		output._conn = conn
+	}
+
+	frame := message
+	if output.Config.Protocol != "udp" {
+		if output.Config.Framing == "newline" {
+			frame = append(message, '\n')
+		} else {
+			frame = append([]byte(fmt.Sprintf("%d ", len(message))), message...)
+		}
+	}
+
+	output._conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+	_, err := output._conn.Write(frame)
+	return err
+}
+
+func (output *SyslogOutput) _Dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: syslogWriteTimeout}
+	switch output.Config.Protocol {
+	case "tls":
+		return tls.DialWithDialer(dialer, "tcp", output.Config.Address, output._tlsConfig)
+	case "tcp":
+		return dialer.Dial("tcp", output.Config.Address)
+	default:
+		return dialer.Dial("udp", output.Config.Address)
+	}
+}
+
+func (output *SyslogOutput) _Close() {
+	if output._conn != nil {
+		output._conn.Close()
+		output._conn = nil
+	}
+}
+
+// FormatCef returns the security event in ArcSight Common Event Format
+func FormatCef(securityEvent *SecurityEvent, record *EventRecord) string {
+	severity := 5
+	if securityEvent.Critical {
+		severity = 10
+	}
+
+	extensions := []string{
+		"rt=" + cefExtensionValue(I64toa(securityEvent.EventTimeUtcNumber*1000)),
+		"src=" + cefExtensionValue(record.Ip),
+		"dvchost=" + cefExtensionValue(record.Host),
+	}
+	if len(record.Source) > 0 {
+		extensions = append(extensions, "filePath="+cefExtensionValue(record.Source))
+	}
+	extensions = append(extensions, siemExtensions(record.Fields, cefFieldNames, cefExtensionValue)...)
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		cefHeaderValue(siemVendor),
+		cefHeaderValue(siemProduct),
+		cefHeaderValue(Version),
+		cefHeaderValue(siemSignatureId(securityEvent)),
+		cefHeaderValue(siemEventName(securityEvent)),
+		severity,
+		strings.Join(extensions, " "))
+}
+
+// FormatLeef returns the security event in IBM QRadar Log Event Extended Format 1.0 (tab delimited attributes)
+func FormatLeef(securityEvent *SecurityEvent, record *EventRecord) string {
+	severity := 5
+	if securityEvent.Critical {
+		severity = 10
+	}
+
+	attributes := []string{
+		"devTime=" + leefAttributeValue(I64toa(securityEvent.EventTimeUtcNumber*1000)),
+		"devTimeFormat=epoch",
+		"src=" + leefAttributeValue(record.Ip),
+		"sev=" + Itoa(severity),
+		"name=" + leefAttributeValue(siemEventName(securityEvent)),
+		"identHostName=" + leefAttributeValue(record.Host),
+	}
+	if len(record.Source) > 0 {
+		attributes = append(attributes, "filePath="+leefAttributeValue(record.Source))
+	}
+	attributes = append(attributes, siemExtensions(record.Fields, leefFieldNames, leefAttributeValue)...)
+
+	return fmt.Sprintf("LEEF:1.0|%s|%s|%s|%s|%s",
+		leefHeaderValue(siemVendor),
+		leefHeaderValue(siemProduct),
+		leefHeaderValue(Version),
+		leefHeaderValue(siemSignatureId(securityEvent)),
+		strings.Join(attributes, "\t"))
+}
+
+func siemSignatureId(securityEvent *SecurityEvent) string {
+	if securityEvent.SecurityGroupId > 0 {
+		return fmt.Sprintf("%d:%d", securityEvent.SecurityGroupId, securityEvent.SecurityId)
+	}
+	return fmt.Sprintf("%d", securityEvent.SecurityId)
+}
+
+func siemEventName(securityEvent *SecurityEvent) string {
+	if len(securityEvent.Message) > 0 {
+		return securityEvent.Message
+	}
+	return fmt.Sprintf("dhound security event %d", securityEvent.SecurityId)
+}
+
+// siemExtensions converts additional fields to key=value pairs sorted by key
+func siemExtensions(fields map[string]string, names map[string]string, escape func(string) string) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	extensions := make([]string, 0, len(keys))
+	for _, key := range keys {
+		name, found := names[key]
+		if !found {
+			name = siemExtensionKey(key)
+		}
+		if len(name) < 1 {
+			continue
+		}
+		extensions = append(extensions, name+"="+escape(fields[key]))
+	}
+	return extensions
+}
+
+// extension keys can contain only alphanumeric characters
+func siemExtensionKey(key string) string {
+	var buffer bytes.Buffer
+	for _, r := range key {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			buffer.WriteRune(r)
+		}
+	}
+	return buffer.String()
+}
+
+var cefHeaderReplacer = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+var cefExtensionReplacer = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+var leefHeaderReplacer = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+var leefAttributeReplacer = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+var syslogHeaderReplacer = strings.NewReplacer(" ", "_")
+
+func cefHeaderValue(value string) string {
+	return cefHeaderReplacer.Replace(value)
+}
+
+func cefExtensionValue(value string) string {
+	return cefExtensionReplacer.Replace(value)
+}
+
+func leefHeaderValue(value string) string {
+	return leefHeaderReplacer.Replace(value)
+}
+
+func leefAttributeValue(value string) string {
+	return leefAttributeReplacer.Replace(value)
+}
+
+func syslogHeaderValue(value string) string {
+	if len(value) < 1 {
+		return "-"
+	}
+	return syslogHeaderReplacer.Replace(value)
+}
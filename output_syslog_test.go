@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSyslogEvents returns a critical event with characters escaped by the formats and an ordinary one
+func testSyslogEvents() *SecurityEventsContainer {
+	source := "/var/log/a|b=c.log:12"
+	return &SecurityEventsContainer{
+		Source: "/var/log/auth.log",
+		SecurityEvents: []*SecurityEvent{
+			{
+				SecurityId:         10002,
+				SecurityGroupId:    1000,
+				EventTimeUtcNumber: 1772532942,
+				Message:            "login | root = admin\\path\r\nnext",
+				IpAddress:          "203.0.113.5",
+				AdditionalFields:   map[string]string{"user": "a=b\\c\nd\te", "weird key!": "x|y", "?": "skipped"},
+				Critical:           true,
+				Source:             &source,
+			},
+			{
+				SecurityId:         10004,
+				EventTimeUtcNumber: 1772532943,
+				IpAddress:          "203.0.113.6",
+			},
+		},
+	}
+}
+
+func TestFormatCef(t *testing.T) {
+	eventsContainer := testSyslogEvents()
+	host := &HostIdentity{Hostname: "web1"}
+
+	expected := []string{
+		"CEF:0|DHound|dhound-agent|" + Version + `|1000:10002|login \| root = admin\\path  next|10|` +
+			`rt=1772532942000 src=203.0.113.5 dvchost=web1 filePath=/var/log/a|b\=c.log:12 suser=a\=b\\c\nd` + "\te weirdkey=x|y",
+		"CEF:0|DHound|dhound-agent|" + Version + "|10004|dhound security event 10004|5|" +
+			"rt=1772532943000 src=203.0.113.6 dvchost=web1 filePath=/var/log/auth.log",
+	}
+
+	for i, securityEvent := range eventsContainer.SecurityEvents {
+		cef := FormatCef(securityEvent, NewEventRecord(securityEvent, eventsContainer, host))
+		if cef != expected[i] {
+			t.Errorf("cef of event %d is\n%q\nexpected\n%q", i, cef, expected[i])
+		}
+	}
+}
+
+func TestFormatLeef(t *testing.T) {
+	eventsContainer := testSyslogEvents()
+	host := &HostIdentity{Hostname: "web1"}
+
+	expected := []string{
+		"LEEF:1.0|DHound|dhound-agent|" + Version + "|1000:10002|devTime=1772532942000\tdevTimeFormat=epoch\tsrc=203.0.113.5\tsev=10\t" +
+			"name=login | root = admin\\path  next\tidentHostName=web1\tfilePath=/var/log/a|b=c.log:12\tusrName=a=b\\c d e\tweirdkey=x|y",
+		"LEEF:1.0|DHound|dhound-agent|" + Version + "|10004|devTime=1772532943000\tdevTimeFormat=epoch\tsrc=203.0.113.6\tsev=5\t" +
+			"name=dhound security event 10004\tidentHostName=web1\tfilePath=/var/log/auth.log",
+	}
+
+	for i, securityEvent := range eventsContainer.SecurityEvents {
+		leef := FormatLeef(securityEvent, NewEventRecord(securityEvent, eventsContainer, host))
+		if leef != expected[i] {
+			t.Errorf("leef of event %d is\n%q\nexpected\n%q", i, leef, expected[i])
+		}
+	}
+}
+
+var syslogTimestampRegex = regexp.MustCompile(`^(<\d+>1 )\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{3}Z `)
+
+// expectedSyslogMessages returns messages of the test events with TIMESTAMP in place of the time of sending
+func expectedSyslogMessages() []string {
+	eventsContainer := testSyslogEvents()
+	host := &HostIdentity{Hostname: "web 1"}
+
+	return []string{
+		"<82>1 TIMESTAMP web_1 dhound_agent - 10002 - " + FormatCef(eventsContainer.SecurityEvents[0], NewEventRecord(eventsContainer.SecurityEvents[0], eventsContainer, host)),
+		"<84>1 TIMESTAMP web_1 dhound_agent - 10004 - " + FormatCef(eventsContainer.SecurityEvents[1], NewEventRecord(eventsContainer.SecurityEvents[1], eventsContainer, host)),
+	}
+}
+
+func newTestSyslogOutput(t *testing.T, address string, protocol string, framing string) *SyslogOutput {
+	output := &SyslogOutput{
+		Config: &SyslogOutputConfig{Address: address, Protocol: protocol, Framing: framing, AppName: "dhound agent"},
+		Host:   &HostIdentity{Hostname: "web 1"},
+	}
+	if err := output.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return output
+}
+
+func TestSyslogOutputTcp(t *testing.T) {
+	messages := expectedSyslogMessages()
+
+	for _, framing := range []string{"octetcounting", "newline"} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		received := make(chan string)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				received <- err.Error()
+				return
+			}
+			defer conn.Close()
+
+			conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+			stream, _ := ioutil.ReadAll(conn)
+			received <- string(stream)
+		}()
+
+		output := newTestSyslogOutput(t, listener.Addr().String(), "tcp", framing)
+		output.Send([]*SecurityEventsContainer{testSyslogEvents()})
+		output._Close()
+
+		stream := <-received
+		listener.Close()
+
+		// frames are split by their format, so messages are compared without the time of sending
+		frames := make([]string, 0)
+		reader := bufio.NewReader(strings.NewReader(stream))
+		for {
+			var frame string
+			if framing == "newline" {
+				frame, err = reader.ReadString('\n')
+				if err == nil {
+					frame = strings.TrimSuffix(frame, "\n")
+				}
+			} else {
+				frame, err = readOctetCountingFrame(reader)
+			}
+
+			if err != nil {
+				// the stream ends after the last frame
+				if err != io.EOF || len(frame) > 0 {
+					t.Errorf("%s: stream %q is not framed: %s", framing, stream, err)
+				}
+				break
+			}
+			frames = append(frames, syslogTimestampRegex.ReplaceAllString(frame, "${1}TIMESTAMP "))
+		}
+
+		if strings.Join(frames, "\n") != strings.Join(messages, "\n") {
+			t.Errorf("%s: received stream\n%q\nexpected messages\n%q", framing, stream, messages)
+		}
+	}
+}
+
+// readOctetCountingFrame reads a frame of RFC6587 octet counting, the message length is followed by the space
+func readOctetCountingFrame(reader *bufio.Reader) (string, error) {
+	lengthValue, err := reader.ReadString(' ')
+	if err != nil {
+		return lengthValue, err
+	}
+
+	length, err := strconv.Atoi(strings.TrimSuffix(lengthValue, " "))
+	if err != nil {
+		return lengthValue, err
+	}
+
+	frame := make([]byte, length)
+	_, err = io.ReadFull(reader, frame)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return string(frame), err
+}
+
+func TestSyslogOutputUdp(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	output := newTestSyslogOutput(t, conn.LocalAddr().String(), "udp", "")
+	output.Send([]*SecurityEventsContainer{testSyslogEvents()})
+	defer output._Close()
+
+	// every message is sent in a datagram without framing
+	buffer := make([]byte, 64*1024)
+	for i, message := range expectedSyslogMessages() {
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("datagram %d is not received: %s", i, err)
+		}
+
+		datagram := syslogTimestampRegex.ReplaceAllString(string(buffer[:n]), "${1}TIMESTAMP ")
+		if datagram != message {
+			t.Errorf("datagram %d is\n%q\nexpected\n%q", i, datagram, message)
+		}
+	}
+}
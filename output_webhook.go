@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type WebhookOutputConfig struct {
+	Name    string            `json:"name,omitempty" yaml:"name,omitempty"`
+	Url     string            `json:"url" yaml:"url"`
+	Method  string            `json:"method,omitempty" yaml:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	// go text/template of the request body, executed for every event (see EventRecord for available fields)
+	Template string `json:"template" yaml:"template"`
+	// notify only about events with these sids, if empty - about critical events
+	Sids         []uint `json:"sids,omitempty" yaml:"sids,omitempty"`
+	CriticalOnly bool   `json:"criticalonly,omitempty" yaml:"criticalonly,omitempty"`
+	// max number of notifications per minute
+	RateLimit int    `json:"ratelimit,omitempty" yaml:"ratelimit,omitempty"`
+	Timeout   string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// WebhookNotifier pushes matching events to webhooks right after they are found, not waiting for the queue flush
+type WebhookNotifier struct {
+	Input       chan *SecurityEventsContainer
+	NextChannel chan *SecurityEventsContainer
+	Configs     []WebhookOutputConfig
+	Host        *HostIdentity
+	_webhooks   []*Webhook
+}
+
+// Webhook sends notifications of one webhook config in its own goroutine
+type Webhook struct {
+	Config      *WebhookOutputConfig
+	Input       chan *EventRecord
+	_template   *template.Template
+	_client     *http.Client
+	_allowance  float64
+	_lastCheck  time.Time
+	_suppressed int
+}
+
+var webhookTemplateFuncs = template.FuncMap{
+	// json returns the value as json, so a template can safely put any text into json body
+	"json": func(value interface{}) (string, error) {
+		content, err := json.Marshal(value)
+		return string(content), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+func (notifier *WebhookNotifier) Init() {
+	notifier._webhooks = make([]*Webhook, 0)
+
+	for i := range notifier.Configs {
+		config := &notifier.Configs[i]
+		if len(config.Name) < 1 {
+			config.Name = fmt.Sprintf("webhook%d", i+1)
+		}
+
+		webhook := &Webhook{Config: config}
+		err := webhook.Init()
+		if err != nil {
+			emitLine(logLevel.important, "webhook '%s' is disabled. error: %s", config.Name, err)
+			continue
+		}
+
+		emitLine(logLevel.important, "webhook '%s' is enabled.", config.Name)
+		notifier._webhooks = append(notifier._webhooks, webhook)
+	}
+}
+
+func (notifier *WebhookNotifier) Run() {
+
+	for _, webhook := range notifier._webhooks {
+		go webhook.Run()
+	}
+
+	for eventsContainer := range notifier.Input {
+		if eventsContainer != nil && len(notifier._webhooks) > 0 {
+			for _, securityEvent := range eventsContainer.SecurityEvents {
+				for _, webhook := range notifier._webhooks {
+					if !webhook.Matches(securityEvent) {
+						continue
+					}
+
+					// never block the pipeline because of a slow webhook
+					select {
+					case webhook.Input <- NewEventRecord(securityEvent, eventsContainer, notifier.Host):
+					default:
+						emitLine(logLevel.important, "webhook '%s': too many notifications in progress, event %d is skipped.", webhook.Config.Name, securityEvent.SecurityId)
+					}
+				}
+			}
+		}
+
+		notifier.NextChannel <- eventsContainer
+	}
+}
+
+func (webhook *Webhook) Init() error {
+	config := webhook.Config
+
+	if len(config.Url) < 1 {
+		return errors.New("url is not specified")
+	}
+	if len(config.Template) < 1 {
+		return errors.New("template is not specified")
+	}
+
+	compiledTemplate, err := template.New(config.Name).Funcs(webhookTemplateFuncs).Parse(config.Template)
+	if err != nil {
+		return errors.New(fmt.Sprintf("incorrect template: %s", err))
+	}
+	webhook._template = compiledTemplate
+
+	if len(config.Method) < 1 {
+		config.Method = "POST"
+	}
+	if config.RateLimit <= 0 {
+		config.RateLimit = 30
+	}
+
+	timeout := 10 * time.Second
+	if len(config.Timeout) > 0 {
+		timeout, err = time.ParseDuration(config.Timeout)
+		if err != nil {
+			return errors.New(fmt.Sprintf("incorrect timeout '%s': %s", config.Timeout, err))
+		}
+	}
+
+	webhook._client = &http.Client{Timeout: timeout}
+	webhook._allowance = float64(config.RateLimit)
+	webhook._lastCheck = time.Now()
+	webhook.Input = make(chan *EventRecord, 100)
+
+	return nil
+}
+
+func (webhook *Webhook) Matches(securityEvent *SecurityEvent) bool {
+	if len(webhook.Config.Sids) > 0 {
+		if !ContainsUint(webhook.Config.Sids, securityEvent.SecurityId) {
+			return false
+		}
+		return !webhook.Config.CriticalOnly || securityEvent.Critical
+	}
+
+	return securityEvent.Critical
+}
+
+func (webhook *Webhook) Run() {
+	for record := range webhook.Input {
+		if !webhook._Allow() {
+			webhook._suppressed++
+			continue
+		}
+
+		if webhook._suppressed > 0 {
+			emitLine(logLevel.important, "webhook '%s': %d notifications were suppressed by the rate limit %d per minute.", webhook.Config.Name, webhook._suppressed, webhook.Config.RateLimit)
+			webhook._suppressed = 0
+		}
+
+		err := webhook.Send(record)
+		if err != nil {
+			emitLine(logLevel.important, "webhook '%s': failed sending event %d. error: %s", webhook.Config.Name, record.Sid, err)
+		}
+	}
+}
+
+func (webhook *Webhook) Send(record *EventRecord) error {
+	var body bytes.Buffer
+	err := webhook._template.Execute(&body, record)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(webhook.Config.Method, webhook.Config.Url, &body)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range webhook.Config.Headers {
+		request.Header.Set(key, value)
+	}
+
+	resp, err := webhook._client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		content, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(fmt.Sprintf("status code %d: %s", resp.StatusCode, content))
+	}
+
+	return nil
+}
+
+// _Allow implements token bucket: RateLimit notifications per minute with the same burst
+func (webhook *Webhook) _Allow() bool {
+	now := time.Now()
+	rate := float64(webhook.Config.RateLimit)
+
+	webhook._allowance += now.Sub(webhook._lastCheck).Minutes() * rate
+	webhook._lastCheck = now
+	if webhook._allowance > rate {
+		webhook._allowance = rate
+	}
+
+	if webhook._allowance < 1 {
+		return false
+	}
+
+	webhook._allowance--
+	return true
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// EventsOutput delivers flushed batches of security events to a destination besides the dhound gateway
+type EventsOutput interface {
+	Name() string
+	Init() error
+	Send(eventsContainers []*SecurityEventsContainer)
+}
+
+// OutputsHub copies every flushed batch to the configured outputs and passes it further to the gateway,
+// critical events of the fast path are passed to the separate channel of the gateway
+type OutputsHub struct {
+	Input               chan []*SecurityEventsContainer
+	NextChannel         chan []*SecurityEventsContainer
+	CriticalInput       chan []*SecurityEventsContainer
+	CriticalNextChannel chan []*SecurityEventsContainer
+	Outputs             []EventsOutput
+	_channels           []chan []*SecurityEventsContainer
+	_dropped            []*outputDrops
+	_pending            sync.WaitGroup
+}
+
+// outputDrops counts batches which are not passed to the output while its buffer is full
+type outputDrops struct {
+	batches int64
+	events  int64
+}
+
+func CreateOutputs(config *MainConfig) []EventsOutput {
+	outputs := make([]EventsOutput, 0)
+	host := NewHostIdentity(config)
+
+	if config.Output.File != nil {
+		outputs = append(outputs, &FileOutput{
+			Config: config.Output.File,
+			Host:   host,
+		})
+	}
+
+	if config.Output.Syslog != nil {
+		outputs = append(outputs, &SyslogOutput{
+			Config: config.Output.Syslog,
+			Host:   host,
+		})
+	}
+
+	if config.Output.Elasticsearch != nil {
+		outputs = append(outputs, &ElasticsearchOutput{
+			Config: config.Output.Elasticsearch,
+			Host:   host,
+		})
+	}
+
+	if config.Output.Splunk != nil {
+		outputs = append(outputs, &SplunkOutput{
+			Config: config.Output.Splunk,
+			Host:   host,
+		})
+	}
+
+	return outputs
+}
+
+func (hub *OutputsHub) Init() {
+	outputs := make([]EventsOutput, 0)
+	for _, output := range hub.Outputs {
+		err := output.Init()
+		if err != nil {
+			emitLine(logLevel.important, "output '%s' is disabled. error: %s", output.Name(), err)
+			continue
+		}
+
+		emitLine(logLevel.important, "output '%s' is enabled.", output.Name())
+		outputs = append(outputs, output)
+	}
+	hub.Outputs = outputs
+
+	// every output has its own small buffer, batches are dropped when it's full,
+	// so a slow or unavailable output does not stop the others and the gateway
+	hub._channels = make([]chan []*SecurityEventsContainer, len(hub.Outputs))
+	hub._dropped = make([]*outputDrops, len(hub.Outputs))
+	for i := range hub.Outputs {
+		hub._channels[i] = make(chan []*SecurityEventsContainer, 16)
+		hub._dropped[i] = &outputDrops{}
+	}
+}
+
+func (hub *OutputsHub) Run() {
+
+	for i, output := range hub.Outputs {
+		go func(output EventsOutput, channel chan []*SecurityEventsContainer, dropped *outputDrops) {
+			for eventsContainers := range channel {
+				output.Send(eventsContainers)
+				hub._pending.Done()
+
+				if len(channel) == 0 && atomic.LoadInt64(&dropped.batches) > 0 {
+					batches := atomic.SwapInt64(&dropped.batches, 0)
+					events := atomic.SwapInt64(&dropped.events, 0)
+					emitLine(logLevel.important, "output '%s' caught up, %d batches with %d events were not sent to it.", output.Name(), batches, events)
+				}
+			}
+		}(output, hub._channels[i], hub._dropped[i])
+	}
+
+	go hub._RunCritical()
+
+	for eventsContainers := range hub.Input {
+		hub._CopyToOutputs(eventsContainers)
+		hub.NextChannel <- eventsContainers
+	}
+}
+
+// _RunCritical passes critical events to the gateway apart from other batches, so they don't wait for the gateway
+// or outputs busy with them, critical events are copied to the outputs after they are passed
+func (hub *OutputsHub) _RunCritical() {
+	for eventsContainers := range hub.CriticalInput {
+		// batches are pending until they are copied, so waiting for the outputs doesn't finish before the copy
+		hub._pending.Add(1)
+		hub.CriticalNextChannel <- eventsContainers
+		hub._CopyToOutputs(eventsContainers)
+		hub._pending.Done()
+	}
+}
+
+func (hub *OutputsHub) _CopyToOutputs(eventsContainers []*SecurityEventsContainer) {
+	for i, channel := range hub._channels {
+		hub._pending.Add(1)
+		select {
+		case channel <- eventsContainers:
+		default:
+			hub._pending.Done()
+			hub._Drop(i, eventsContainers)
+		}
+	}
+}
+
+// _Drop counts the batch not passed to the output, the first dropped batch is reported until the output catches up
+func (hub *OutputsHub) _Drop(i int, eventsContainers []*SecurityEventsContainer) {
+	events := 0
+	for _, eventsContainer := range eventsContainers {
+		events += len(eventsContainer.SecurityEvents)
+	}
+
+	dropped := hub._dropped[i]
+	atomic.AddInt64(&dropped.events, int64(events))
+	if atomic.AddInt64(&dropped.batches, 1) == 1 {
+		emitLine(logLevel.important, "output '%s' does not keep up, batches are not sent to it until it catches up.", hub.Outputs[i].Name())
+	}
+}
+
+// Dropped returns numbers of batches and events not passed to the output since it caught up last time
+func (hub *OutputsHub) Dropped(i int) (int64, int64) {
+	return atomic.LoadInt64(&hub._dropped[i].batches), atomic.LoadInt64(&hub._dropped[i].events)
+}
+
+// Wait blocks until the outputs send all batches passed to them
+func (hub *OutputsHub) Wait() {
+	hub._pending.Wait()
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// testOutput records batches sent to it, sending is blocked until the output is released when it's stalled
+type testOutput struct {
+	_mutex   sync.Mutex
+	_batches [][]*SecurityEventsContainer
+	_release chan struct{}
+}
+
+func newTestOutput(stalled bool) *testOutput {
+	output := &testOutput{_release: make(chan struct{})}
+	if !stalled {
+		close(output._release)
+	}
+	return output
+}
+
+func (output *testOutput) Name() string {
+	return "test"
+}
+
+func (output *testOutput) Init() error {
+	return nil
+}
+
+func (output *testOutput) Send(eventsContainers []*SecurityEventsContainer) {
+	<-output._release
+
+	output._mutex.Lock()
+	output._batches = append(output._batches, eventsContainers)
+	output._mutex.Unlock()
+}
+
+func (output *testOutput) Batches() int {
+	output._mutex.Lock()
+	defer output._mutex.Unlock()
+	return len(output._batches)
+}
+
+func newTestOutputsHub(outputs ...EventsOutput) *OutputsHub {
+	hub := &OutputsHub{
+		Input:               make(chan []*SecurityEventsContainer),
+		NextChannel:         make(chan []*SecurityEventsContainer),
+		CriticalInput:       make(chan []*SecurityEventsContainer),
+		CriticalNextChannel: make(chan []*SecurityEventsContainer),
+		Outputs:             outputs,
+	}
+	hub.Init()
+	go hub.Run()
+	return hub
+}
+
+func testBatch(source string, events int) []*SecurityEventsContainer {
+	eventsContainer := &SecurityEventsContainer{Source: source}
+	for i := 0; i < events; i++ {
+		eventsContainer.SecurityEvents = append(eventsContainer.SecurityEvents, &SecurityEvent{SecurityId: 10004, IpAddress: "203.0.113.5"})
+	}
+	return []*SecurityEventsContainer{eventsContainer}
+}
+
+// critical events are passed to the gateway while the hub waits for the gateway to take other batches
+func TestOutputsHubCriticalLane(t *testing.T) {
+	output := newTestOutput(false)
+	hub := newTestOutputsHub(output)
+
+	// the gateway doesn't read batches, the hub is blocked passing the batch
+	hub.Input <- testBatch("bulk", 1)
+
+	critical := testBatch("critical", 1)
+	select {
+	case hub.CriticalInput <- critical:
+	case <-time.After(5 * time.Second):
+		t.Fatal("critical events are not taken while the gateway is busy")
+	}
+
+	select {
+	case eventsContainers := <-hub.CriticalNextChannel:
+		if eventsContainers[0].Source != "critical" {
+			t.Errorf("batch of %s is passed by the critical lane", eventsContainers[0].Source)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("critical events are not passed while the gateway is busy")
+	}
+
+	<-hub.NextChannel
+	hub.Wait()
+	if output.Batches() != 2 {
+		t.Errorf("output received %d batches, expected 2", output.Batches())
+	}
+}
+
+// a stalled output doesn't stop passing batches to the gateway and the other outputs, batches not taken by it are counted
+func TestOutputsHubStalledOutput(t *testing.T) {
+	stalled := newTestOutput(true)
+	output := newTestOutput(false)
+	hub := newTestOutputsHub(stalled, output)
+
+	passed := make(chan int)
+	go func() {
+		count := 0
+		for range hub.NextChannel {
+			count++
+			if count == 100 {
+				passed <- count
+			}
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		select {
+		case hub.Input <- testBatch("bulk", 2):
+		case <-time.After(5 * time.Second):
+			t.Fatalf("batch %d is not taken while the output is stalled", i)
+		}
+	}
+
+	select {
+	case <-passed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("batches are not passed to the gateway while the output is stalled")
+	}
+
+	// the output buffer is full and one batch is being sent
+	batches, events := hub.Dropped(0)
+	if batches < 100-17 || batches > 100-16 || events != batches*2 {
+		t.Errorf("%d batches with %d events are dropped for the stalled output", batches, events)
+	}
+
+	close(stalled._release)
+	hub.Wait()
+
+	if int64(stalled.Batches()) != 100-batches {
+		t.Errorf("stalled output received %d batches, expected %d", stalled.Batches(), 100-batches)
+	}
+	// the other output isn't limited by the buffer of the stalled one
+	if output.Batches() <= 17 {
+		t.Errorf("output received %d batches of 100", output.Batches())
+	}
+
+	// counters are reset when the output catches up
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if batches, _ := hub.Dropped(0); batches == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("dropped batches are not reset after the output caught up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// self-monitoring events of the agent
+	AgentOverloadedSid uint = 90001
+	AgentRecoveredSid  uint = 90002
+	AgentEventsSource       = "dhound-agent"
+)
+
+var overloadDroppedEvents = expvar.NewMap("overload_dropped_events")
+
+type OverloadConfig struct {
+	// megabytes of security events waiting in the pipeline, crawlers are paused when the budget is exhausted
+	MemoryBudget int `json:"memorybudget,omitempty" yaml:"memorybudget,omitempty"`
+	// percent of the budget when the policy is applied to non-critical events
+	Threshold int `json:"threshold,omitempty" yaml:"threshold,omitempty"`
+	// backpressure (default), pause, sample or summarize
+	Policy     string `json:"policy,omitempty" yaml:"policy,omitempty"`
+	SampleRate int    `json:"samplerate,omitempty" yaml:"samplerate,omitempty"`
+}
+
+// Normalize sets default budget and policy
+func (config *OverloadConfig) Normalize() error {
+	if config.MemoryBudget <= 0 {
+		config.MemoryBudget = 64
+	}
+
+	if config.Threshold <= 0 || config.Threshold > 100 {
+		config.Threshold = 80
+	}
+
+	config.Policy = strings.ToLower(config.Policy)
+	if len(config.Policy) < 1 {
+		config.Policy = "backpressure"
+	}
+
+	if config.Policy != "backpressure" && config.Policy != "pause" && config.Policy != "sample" && config.Policy != "summarize" {
+		return errors.New(fmt.Sprintf("unknown overload policy '%s', expected backpressure, pause, sample or summarize", config.Policy))
+	}
+
+	if config.SampleRate <= 0 {
+		config.SampleRate = 10
+	}
+
+	return nil
+}
+
+type overloadCounter struct {
+	seen    uint64
+	dropped uint64
+	first   *SecurityEvent
+}
+
+// OverloadGuard accounts memory of security events from crawlers until they are committed by the gateway
+type OverloadGuard struct {
+	Config      *OverloadConfig
+	NextChannel chan *SecurityEventsContainer
+	_budget     int64
+	_threshold  int64
+	_used       int64
+	_mutex      sync.Mutex
+	_released   *sync.Cond
+	_overloaded bool
+	_reported   bool
+	_since      time.Time
+	_counters   map[uint]*overloadCounter
+	// memory reserved by events of every rule and rules which files are not read until recovery
+	_ruleBytes map[string]int64
+	_paused    map[string]bool
+}
+
+func (guard *OverloadGuard) Init() {
+	guard._budget = int64(guard.Config.MemoryBudget) * 1024 * 1024
+	guard._threshold = guard._budget * int64(guard.Config.Threshold) / 100
+	guard._released = sync.NewCond(&guard._mutex)
+	guard._counters = make(map[uint]*overloadCounter)
+	guard._ruleBytes = make(map[string]int64)
+	guard._paused = make(map[string]bool)
+}
+
+// Run reports overload state and summaries as security events of the agent
+func (guard *OverloadGuard) Run() {
+	for range time.Tick(10 * time.Second) {
+		eventsContainer := guard._Report()
+		if eventsContainer != nil {
+			guard.NextChannel <- eventsContainer
+		}
+	}
+}
+
+// Admit decides if the event of the rule is added to the container and reserves its memory,
+// critical events are always admitted, backpressure and pause policies don't drop events
+// but stop reading of files with Throttled
+func (guard *OverloadGuard) Admit(rule string, securityEvent *SecurityEvent, eventsContainer *SecurityEventsContainer) bool {
+	size := int64(securityEvent.EstimatedJsonSize())
+
+	guard._mutex.Lock()
+	defer guard._mutex.Unlock()
+
+	if !securityEvent.Critical && guard._used+size > guard._threshold {
+		if !guard._overloaded {
+			guard._overloaded = true
+			guard._since = time.Now()
+			emitLine(logLevel.important, "Agent is overloaded, %d of %d bytes are used. Policy '%s' is applied to non-critical events.", guard._used, guard._budget, guard.Config.Policy)
+		}
+
+		switch guard.Config.Policy {
+		case "pause":
+			if !guard._paused[rule] {
+				guard._PauseLargestRule()
+			}
+		case "sample", "summarize":
+			counter := guard._counters[securityEvent.SecurityId]
+			if counter == nil {
+				counter = &overloadCounter{}
+				guard._counters[securityEvent.SecurityId] = counter
+			}
+			counter.seen++
+
+			if guard.Config.Policy != "sample" || (counter.seen-1)%uint64(guard.Config.SampleRate) != 0 {
+				counter.dropped++
+				if counter.first == nil {
+					counter.first = securityEvent
+				}
+				overloadDroppedEvents.Add(fmt.Sprintf("%d", securityEvent.SecurityId), 1)
+				return false
+			}
+		}
+	}
+
+	guard._used += size
+	guard._ruleBytes[rule] += size
+	eventsContainer._reservedBytes += size
+	if eventsContainer._reservedRules == nil {
+		eventsContainer._reservedRules = make(map[string]int64)
+	}
+	eventsContainer._reservedRules[rule] += size
+
+	return true
+}
+
+// _PauseLargestRule pauses the rule which events take the most memory among running rules
+func (guard *OverloadGuard) _PauseLargestRule() {
+	largest := ""
+	for rule, bytes := range guard._ruleBytes {
+		if !guard._paused[rule] && (len(largest) < 1 || bytes > guard._ruleBytes[largest]) {
+			largest = rule
+		}
+	}
+
+	if len(largest) > 0 {
+		guard._paused[largest] = true
+		emitLine(logLevel.important, "files of rule '%s' are not read until the agent recovers from overload, its events take %d bytes.", largest, guard._ruleBytes[largest])
+	}
+}
+
+// Throttled returns true when files of the rules should not be read because of overload,
+// the crawler keeps the offset and continues reading after recovery
+func (guard *OverloadGuard) Throttled(rules []*RuleConfig) bool {
+	guard._mutex.Lock()
+	defer guard._mutex.Unlock()
+
+	if !guard._overloaded {
+		return false
+	}
+
+	switch guard.Config.Policy {
+	case "backpressure":
+		return true
+	case "pause":
+		for _, rule := range rules {
+			if guard._paused[rule.RuleFileName] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// WaitRecovered blocks while the agent is overloaded
+func (guard *OverloadGuard) WaitRecovered() {
+	guard._mutex.Lock()
+	defer guard._mutex.Unlock()
+
+	for guard._overloaded {
+		guard._released.Wait()
+	}
+}
+
+// Exhausted returns true when the budget is used up and crawlers should flush and wait
+func (guard *OverloadGuard) Exhausted() bool {
+	guard._mutex.Lock()
+	defer guard._mutex.Unlock()
+
+	return guard._used >= guard._budget
+}
+
+// Wait blocks while the budget is used up
+func (guard *OverloadGuard) Wait() {
+	guard._mutex.Lock()
+	defer guard._mutex.Unlock()
+
+	for guard._used >= guard._budget {
+		guard._released.Wait()
+	}
+}
+
+// WaitReleased blocks until memory of all containers is released
+func (guard *OverloadGuard) WaitReleased() {
+	guard._mutex.Lock()
+	defer guard._mutex.Unlock()
+
+	for guard._used > 0 {
+		guard._released.Wait()
+	}
+}
+
+// Release frees memory reserved by the containers after they are committed
+func (guard *OverloadGuard) Release(eventsContainers []*SecurityEventsContainer) {
+	guard._mutex.Lock()
+	defer guard._mutex.Unlock()
+
+	for _, eventsContainer := range eventsContainers {
+		guard._used -= eventsContainer._reservedBytes
+		eventsContainer._reservedBytes = 0
+
+		for rule, bytes := range eventsContainer._reservedRules {
+			guard._ruleBytes[rule] -= bytes
+			if guard._ruleBytes[rule] <= 0 {
+				delete(guard._ruleBytes, rule)
+			}
+		}
+		eventsContainer._reservedRules = nil
+	}
+
+	// leave overload state only when half of the threshold is free to avoid flapping
+	if guard._overloaded && guard._used < guard._threshold/2 {
+		guard._overloaded = false
+		guard._paused = make(map[string]bool)
+		emitLine(logLevel.important, "Agent recovered from overload started at %s.", guard._since.Format(time.RFC3339))
+	}
+
+	guard._released.Broadcast()
+}
+
+func (guard *OverloadGuard) _Report() *SecurityEventsContainer {
+	guard._mutex.Lock()
+	defer guard._mutex.Unlock()
+
+	securityEvents := make([]*SecurityEvent, 0)
+	now := DateToCustomLong(time.Now())
+	source := AgentEventsSource
+
+	dropped := make([]string, 0)
+	var total uint64 = 0
+
+	for sid, counter := range guard._counters {
+		if counter.dropped < 1 {
+			continue
+		}
+		total += counter.dropped
+		dropped = append(dropped, fmt.Sprintf("%d:%d", sid, counter.dropped))
+
+		// keep one event for every sid with number of summarized events
+		if guard.Config.Policy == "summarize" {
+			summaryEvent := *counter.first
+			summaryEvent.AdditionalFields = map[string]string{"overloadcount": fmt.Sprintf("%d", counter.dropped)}
+			for key, value := range counter.first.AdditionalFields {
+				summaryEvent.AdditionalFields[key] = value
+			}
+			summaryEvent.Message = fmt.Sprintf("%s (%d events summarized during agent overload)", counter.first.Message, counter.dropped)
+			securityEvents = append(securityEvents, &summaryEvent)
+		}
+	}
+	sort.Strings(dropped)
+	guard._counters = make(map[uint]*overloadCounter)
+
+	paused := make([]string, 0)
+	for rule := range guard._paused {
+		paused = append(paused, rule)
+	}
+	sort.Strings(paused)
+
+	// the reports are not critical, so they don't take the fast path and don't fire webhooks every 10 seconds of overload
+	reportOverload := total > 0 || (guard._overloaded && !guard._reported)
+	if reportOverload {
+		securityEvents = append(securityEvents, &SecurityEvent{
+			SecurityId:         AgentOverloadedSid,
+			EventTimeUtcNumber: now,
+			Message:            fmt.Sprintf("Agent is overloaded, policy '%s' is applied to %d non-critical events", guard.Config.Policy, total),
+			IpAddress:          "local",
+			Source:             &source,
+			AdditionalFields: map[string]string{
+				"policy":  guard.Config.Policy,
+				"since":   guard._since.Format(time.RFC3339),
+				"used":    fmt.Sprintf("%d", guard._used),
+				"budget":  fmt.Sprintf("%d", guard._budget),
+				"dropped": strings.Join(dropped, ","),
+				"paused":  strings.Join(paused, ","),
+			},
+		})
+	}
+
+	if !guard._overloaded && (guard._reported || reportOverload) {
+		securityEvents = append(securityEvents, &SecurityEvent{
+			SecurityId:         AgentRecoveredSid,
+			EventTimeUtcNumber: now,
+			Message:            "Agent recovered from overload",
+			IpAddress:          "local",
+			Source:             &source,
+			AdditionalFields:   map[string]string{"since": guard._since.Format(time.RFC3339)},
+		})
+	}
+
+	guard._reported = guard._overloaded
+
+	if len(securityEvents) < 1 {
+		return nil
+	}
+
+	return &SecurityEventsContainer{
+		Source:         AgentEventsSource,
+		SecurityEvents: securityEvents,
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+)
+
+// reports of overload are ordinary events, so they don't fire webhooks of critical events on every report
+func TestOverloadGuardReport(t *testing.T) {
+	guard := &OverloadGuard{Config: &OverloadConfig{MemoryBudget: 1, Policy: "summarize"}}
+	guard.Config.Normalize()
+	guard.Init()
+
+	eventsContainer := &SecurityEventsContainer{}
+	admitted := 0
+	for i := 0; i < 20000; i++ {
+		securityEvent := &SecurityEvent{SecurityId: 10004, IpAddress: "203.0.113.5", Message: "Failed password for root"}
+		if guard.Admit("sshd", securityEvent, eventsContainer) {
+			admitted++
+		}
+	}
+	if admitted == 20000 {
+		t.Fatal("events are not dropped by overload")
+	}
+
+	report := guard._Report()
+	if report == nil || len(report.SecurityEvents) != 2 {
+		t.Fatalf("report of overload is %v, expected summary and overload events", report)
+	}
+	reported := report.SecurityEvents
+	if report.SecurityEvents[1].SecurityId != AgentOverloadedSid || report.SecurityEvents[0].AdditionalFields["overloadcount"] != Itoa(20000-admitted) {
+		t.Errorf("report of %d dropped events is %v", 20000-admitted, report.SecurityEvents)
+	}
+
+	// the overload is reported once while no events are dropped
+	if report := guard._Report(); report != nil {
+		t.Errorf("overload is reported again: %v", report.SecurityEvents)
+	}
+
+	guard.Release([]*SecurityEventsContainer{eventsContainer})
+	report = guard._Report()
+	if report == nil || len(report.SecurityEvents) != 1 || report.SecurityEvents[0].SecurityId != AgentRecoveredSid {
+		t.Fatalf("report of recovery is %v", report)
+	}
+	reported = append(reported, report.SecurityEvents...)
+
+	for _, securityEvent := range reported {
+		if securityEvent.Critical {
+			t.Errorf("event %d of the report is critical", securityEvent.SecurityId)
+		}
+	}
+}
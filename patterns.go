@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+)
+
+// named regexes used in rules as %{NAME} or %{NAME:field}, patterns of patterns.d directory override them
+var defaultPatterns = map[string]string{
+	"SYSLOGTIMESTAMP": `\S{3} +\d{1,2} \d{2}:\d{2}:\d{2}`,
+	"HTTPDATE":        `\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}`,
+	"IPV4":            `(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)`,
+	"IPV6":            `(?:(?:[0-9A-Fa-f]{1,4}:){7}[0-9A-Fa-f]{1,4}|(?:[0-9A-Fa-f]{1,4}(?::[0-9A-Fa-f]{1,4}){0,6})?::(?:(?:[0-9A-Fa-f]{1,4}:){0,5}%{IPV4}|[0-9A-Fa-f]{1,4}(?::[0-9A-Fa-f]{1,4}){0,6})?)`,
+	"IP":              `%{IPV6}|%{IPV4}`,
+	// beginning of addresses of local and private networks
+	"PRIVATEIP": `0\.|127\.0\.0\.1|192\.168\.|10\.|172\.(?:1[6-9]|2[0-9]|3[0-1])\.|fc00:|fe80:`,
+	"USER":      `[a-zA-Z0-9._-]+`,
+	"PORT":      `\d{1,5}`,
+}
+
+// patterns referencing each other deeper are considered recursive
+const maxPatternsDepth = 10
+
+var patternReferenceRegex = regexp.MustCompile(`%\{([A-Z][A-Z0-9_]*)(?::(\w+))?\}`)
+var patternNameRegex = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// LoadPatterns reads named patterns from yml files of the directory, every file is a map of names to regexes,
+// malformed files are skipped like files of rules
+func LoadPatterns(directory string) (map[string]string, error) {
+	patterns := make(map[string]string)
+	for name, pattern := range defaultPatterns {
+		patterns[name] = pattern
+	}
+
+	if !IsFileExists(directory) {
+		return patterns, nil
+	}
+
+	patternFiles, err := DiscoverYamlConfigs(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, patternFile := range patternFiles {
+		filePatterns, err := loadPatternsFile(patternFile)
+		if err != nil {
+			emit(logLevel.important, "Failed loading file '%s': %s.\n", patternFile, err)
+			continue
+		}
+
+		for name, pattern := range filePatterns {
+			patterns[name] = pattern
+		}
+	}
+
+	return patterns, nil
+}
+
+func loadPatternsFile(patternFile string) (map[string]string, error) {
+	filePatterns := make(map[string]string)
+	err := LoadYamlFile(patternFile, &filePatterns)
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range filePatterns {
+		if !patternNameRegex.MatchString(name) {
+			return nil, errors.New(fmt.Sprintf("incorrect pattern name '%s' in file '%s', expected upper case letters, digits and _", name, path.Base(patternFile)))
+		}
+	}
+
+	return filePatterns, nil
+}
+
+// ExpandPatterns replaces %{NAME} by the named pattern and %{NAME:field} by the pattern captured as the field
+func ExpandPatterns(expression string, patterns map[string]string) (string, error) {
+	return expandPatterns(expression, patterns, 0)
+}
+
+func expandPatterns(expression string, patterns map[string]string, depth int) (string, error) {
+	if depth > maxPatternsDepth {
+		return "", errors.New(fmt.Sprintf("patterns are nested deeper than %d, probably they reference each other", maxPatternsDepth))
+	}
+
+	var err error
+	expanded := patternReferenceRegex.ReplaceAllStringFunc(expression, func(reference string) string {
+		groups := patternReferenceRegex.FindStringSubmatch(reference)
+
+		pattern, found := patterns[groups[1]]
+		if !found {
+			if err == nil {
+				err = errors.New(fmt.Sprintf("unknown pattern '%s'", groups[1]))
+			}
+			return reference
+		}
+
+		pattern, expandErr := expandPatterns(pattern, patterns, depth+1)
+		if expandErr != nil {
+			if err == nil {
+				err = expandErr
+			}
+			return reference
+		}
+
+		if len(groups[2]) > 0 {
+			return "(?P<" + groups[2] + ">" + pattern + ")"
+		}
+		return "(?:" + pattern + ")"
+	})
+
+	return expanded, err
+}
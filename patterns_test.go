@@ -0,0 +1,197 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// expandedRuleEvents loads the shipped rule file and expands patterns of its regexes
+func expandedRuleEvents(t *testing.T, name string, patterns map[string]string) []SecurityEventConfig {
+	var rule RuleConfig
+	err := LoadYamlFile(filepath.Join("config", "rules.d", name+".yml"), &rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range rule.Events {
+		event := &rule.Events[i]
+
+		expanded, err := ExpandPatterns(event.Regex, patterns)
+		if err != nil {
+			t.Fatalf("rule '%s': failed expanding '%s': %s", name, event.Regex, err)
+		}
+		event.CompiledRegex = regexp.MustCompile(expanded)
+
+		event.ExcludeCompiledRegex = make(map[string]*regexp.Regexp)
+		for field, exclude := range event.Exclude {
+			expanded, err := ExpandPatterns(exclude, patterns)
+			if err != nil {
+				t.Fatalf("rule '%s': failed expanding '%s': %s", name, exclude, err)
+			}
+			event.ExcludeCompiledRegex[field] = regexp.MustCompile(expanded)
+		}
+	}
+
+	return rule.Events
+}
+
+func TestShippedRulePatterns(t *testing.T) {
+	patterns, err := LoadPatterns(filepath.Join("config", "patterns.d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rule     string
+		line     string
+		fields   map[string]string
+		excluded bool
+	}{
+		{"sshd", "Mar  3 10:15:42 web1 sshd[1234]: Accepted password for admin from 203.0.113.5 port 52144 ssh2",
+			map[string]string{"eventTime": "Mar  3 10:15:42", "user": "admin", "ip": "203.0.113.5"}, false},
+		{"sshd", "Mar 13 10:15:42 web1 sshd[1234]: Failed password for invalid user oracle from 2001:db8::5 port 52144 ssh2",
+			map[string]string{"eventTime": "Mar 13 10:15:42", "user": "oracle", "ip": "2001:db8::5"}, false},
+		{"sshd", "Mar  3 10:15:42 web1 login[99]: pam_unix(login:session): session opened for user root by LOGIN(uid=0)",
+			map[string]string{"eventTime": "Mar  3 10:15:42", "user": "root", "ip": "local"}, false},
+		{"installations", "Mar  3 10:15:42 web1 yum[2211]: Installed: nginx-1.20.1-1.el7.x86_64",
+			map[string]string{"eventTime": "Mar  3 10:15:42", "soft": "nginx-1.20.1-1.el7.x86_64", "ip": "local"}, false},
+		{"installations", "Mar  3 10:15:42 web1 yum[2211]: Erased: httpd-2.4.6-97.el7.x86_64",
+			map[string]string{"eventTime": "Mar  3 10:15:42", "soft": "httpd-2.4.6-97.el7.x86_64", "ip": "local"}, false},
+		{"tcp-out", "Mar  3 10:15:42 web1 kernel: [123.456] OUT TCP: IN= OUT=eth0 SRC=10.0.0.2 DST=203.0.113.5 LEN=60 TOS=0x00 PREC=0x00 TTL=64 ID=1 DF PROTO=TCP SPT=40000 DPT=443 WINDOW=29200 RES=0x00 SYN URGP=0",
+			map[string]string{"eventTime": "Mar  3 10:15:42", "ip": "203.0.113.5", "port": "443"}, false},
+		{"tcp-out", "Mar  3 10:15:42 web1 kernel: [123.456] OUT TCP: IN= OUT=eth0 SRC=10.0.0.2 DST=172.20.0.5 LEN=60 TOS=0x00 PREC=0x00 TTL=64 ID=1 DF PROTO=TCP SPT=40000 DPT=443 WINDOW=29200 RES=0x00 SYN URGP=0",
+			map[string]string{"eventTime": "Mar  3 10:15:42", "ip": "172.20.0.5", "port": "443"}, true},
+		{"tcp-out", "Mar  3 10:15:42 web1 kernel: [123.456] OUT TCP: IN= OUT=eth0 SRC=10.0.0.2 DST=172.32.0.5 LEN=60 TOS=0x00 PREC=0x00 TTL=64 ID=1 DF PROTO=TCP SPT=40000 DPT=443 WINDOW=29200 RES=0x00 SYN URGP=0",
+			map[string]string{"eventTime": "Mar  3 10:15:42", "ip": "172.32.0.5", "port": "443"}, false},
+		{"win_firewall_logs", "2026-03-03 10:15:42 ALLOW TCP 10.0.0.2 203.0.113.5 50000 443 0 - - - - - - - SEND",
+			map[string]string{"eventTime": "2026-03-03 10:15:42", "ip": "203.0.113.5", "port": "443"}, false},
+		{"win_firewall_logs", "2026-03-03 10:15:42 ALLOW TCP 10.0.0.2 192.168.1.10 50000 443 0 - - - - - - - SEND",
+			map[string]string{"eventTime": "2026-03-03 10:15:42", "ip": "192.168.1.10", "port": "443"}, true},
+		{"win_firewall_logs", "2026-03-03 10:15:42 ALLOW TCP 10.0.0.2 127.0.0.1 50000 443 0 - - - - - - - SEND",
+			map[string]string{"eventTime": "2026-03-03 10:15:42", "ip": "127.0.0.1", "port": "443"}, true},
+	}
+
+	for _, test := range tests {
+		var fields map[string]string
+		var event SecurityEventConfig
+		for _, event = range expandedRuleEvents(t, test.rule, patterns) {
+			fields = event.Match([]byte(test.line))
+			if fields != nil {
+				break
+			}
+		}
+
+		if !reflect.DeepEqual(fields, test.fields) {
+			t.Errorf("rule '%s': fields of %q are %v, expected %v", test.rule, test.line, fields, test.fields)
+			continue
+		}
+
+		excluded := false
+		for field, regex := range event.ExcludeCompiledRegex {
+			if regex.MatchString(fields[field]) {
+				excluded = true
+			}
+		}
+		if excluded != test.excluded {
+			t.Errorf("rule '%s': excluded %q is %v, expected %v", test.rule, test.line, excluded, test.excluded)
+		}
+	}
+}
+
+func TestExpandPatterns(t *testing.T) {
+	patterns := map[string]string{
+		"PORT":     `\d+`,
+		"ADDRESS":  `%{IPV4}:%{PORT:port}`,
+		"IPV4":     `\d+\.\d+\.\d+\.\d+`,
+		"SELF":     `a%{SELF}`,
+		"PING":     `%{PONG}`,
+		"PONG":     `%{PING}`,
+		"UNKNOWN1": `%{MISSING}`,
+	}
+	// a chain of patterns deeper than the limit is rejected like recursive ones
+	for i := 0; i <= maxPatternsDepth; i++ {
+		patterns["DEEP"+Itoa(i)] = "%{DEEP" + Itoa(i+1) + "}"
+	}
+	patterns["DEEP"+Itoa(maxPatternsDepth+1)] = "x"
+
+	tests := []struct {
+		expression string
+		expanded   string
+		err        string
+	}{
+		{`^%{PORT}$`, `^(?:\d+)$`, ""},
+		{`^%{PORT:port}$`, `^(?P<port>\d+)$`, ""},
+		{`%{ADDRESS:address}`, `(?P<address>(?:\d+\.\d+\.\d+\.\d+):(?P<port>\d+))`, ""},
+		// references are upper case names, other text is kept
+		{`%{port} %{} %{`, `%{port} %{} %{`, ""},
+		{`%{MISSING}`, "", "unknown pattern 'MISSING'"},
+		{`%{UNKNOWN1}`, "", "unknown pattern 'MISSING'"},
+		{`%{SELF}`, "", "nested deeper"},
+		{`%{PING}`, "", "nested deeper"},
+		{`%{DEEP0}`, "", "nested deeper"},
+		{`%{DEEP2}`, "(?:(?:(?:(?:(?:(?:(?:(?:(?:(?:x))))))))))", ""},
+	}
+
+	for _, test := range tests {
+		expanded, err := ExpandPatterns(test.expression, patterns)
+		if len(test.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("ExpandPatterns('%s') error is '%v', expected '%s'", test.expression, err, test.err)
+			}
+			continue
+		}
+
+		if err != nil || expanded != test.expanded {
+			t.Errorf("ExpandPatterns('%s') = '%s' (%v); expected '%s'", test.expression, expanded, err, test.expanded)
+		}
+	}
+}
+
+func TestLoadPatterns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "patterns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"custom.yml":    "USER: '[a-z]+'\nSSHDPREFIX: '%{SYSLOGTIMESTAMP:eventTime}.+?sshd.+?'\n",
+		"malformed.yml": "PORT: [\n",
+		"name.yml":      "lower_case: 'x'\nPORT: 'y'\n",
+		"list.yml":      "- PORT\n",
+	}
+	for name, content := range files {
+		writeTestLog(t, dir, name, content)
+	}
+
+	patterns, err := LoadPatterns(dir)
+	if err != nil {
+		t.Fatalf("malformed pattern files are not skipped: %s", err)
+	}
+
+	expected := map[string]string{
+		"USER":       `[a-z]+`,
+		"SSHDPREFIX": `%{SYSLOGTIMESTAMP:eventTime}.+?sshd.+?`,
+		"PORT":       defaultPatterns["PORT"],
+		"IP":         defaultPatterns["IP"],
+	}
+	for name, pattern := range expected {
+		if patterns[name] != pattern {
+			t.Errorf("pattern %s is '%s', expected '%s'", name, patterns[name], pattern)
+		}
+	}
+	if _, found := patterns["lower_case"]; found {
+		t.Errorf("pattern of the skipped file is loaded")
+	}
+
+	// the missing directory has built-in patterns only
+	patterns, err = LoadPatterns(filepath.Join(dir, "missing"))
+	if err != nil || !reflect.DeepEqual(patterns, defaultPatterns) {
+		t.Errorf("patterns of the missing directory are %v (%v), expected the built-in ones", patterns, err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+// Pipeline connects stages passing security events from crawlers to the outputs and the gateway
+type Pipeline struct {
+	// crawlers send events containers to the input
+	Input       chan *SecurityEventsContainer
+	SystemState *SystemState
+	Overload    *OverloadGuard
+	_ipEnricher *IpEnricher
+	_notifier   *WebhookNotifier
+	_queue      *Queue
+	_outputsHub *OutputsHub
+	_gate       *HttpGateway
+}
+
+func NewPipeline(options *Options, config *MainConfig, systemState *SystemState) *Pipeline {
+	pipeline := &Pipeline{
+		SystemState: systemState,
+	}
+
+	overload := &OverloadGuard{
+		Config: &config.Overload,
+	}
+	overload.Init()
+
+	gate := &HttpGateway{
+		SystemState:   systemState,
+		Options:       options,
+		MainConfig:    config,
+		Overload:      overload,
+		Input:         make(chan []*SecurityEventsContainer, config.Output.SendBuffer),
+		CriticalInput: make(chan []*SecurityEventsContainer, config.Output.SendBuffer),
+	}
+	gate.Init()
+
+	outputsHub := &OutputsHub{
+		Input:       make(chan []*SecurityEventsContainer),
+		NextChannel: gate.Input,
+		Outputs:     CreateOutputs(config),
+
+		CriticalInput:       make(chan []*SecurityEventsContainer),
+		CriticalNextChannel: gate.CriticalInput,
+	}
+	outputsHub.Init()
+
+	queue := &Queue{
+		Options:     options,
+		Config:      &config.Output.Batch,
+		Input:       make(chan *SecurityEventsContainer),
+		NextChannel: outputsHub.Input,
+	}
+	if !config.Output.Batch.DisableFastPath {
+		queue.CriticalChannel = outputsHub.CriticalInput
+	}
+	queue.Init()
+
+	notifier := &WebhookNotifier{
+		Input:       make(chan *SecurityEventsContainer),
+		NextChannel: queue.Input,
+		Configs:     config.Output.Webhooks,
+		Host:        NewHostIdentity(config),
+	}
+	notifier.Init()
+
+	ipEnricher := &IpEnricher{
+		Input:       make(chan *SecurityEventsContainer),
+		NextChannel: notifier.Input,
+		Options:     options,
+		Config:      config,
+	}
+	ipEnricher.Init()
+
+	overload.NextChannel = ipEnricher.Input
+
+	pipeline.Input = ipEnricher.Input
+	pipeline.Overload = overload
+	pipeline._ipEnricher = ipEnricher
+	pipeline._notifier = notifier
+	pipeline._queue = queue
+	pipeline._outputsHub = outputsHub
+	pipeline._gate = gate
+
+	return pipeline
+}
+
+// Run starts processing messages from channels
+func (pipeline *Pipeline) Run() {
+	go pipeline.SystemState.Sync()
+	go pipeline._ipEnricher.Run()
+	go pipeline._notifier.Run()
+	go pipeline._queue.Run()
+	go pipeline._outputsHub.Run()
+	go pipeline._gate.Run()
+	go pipeline._gate.RunCritical()
+	go pipeline.Overload.Run()
+}
+
+// Wait blocks until all events sent to the input are committed by the gateway and written by the outputs
+func (pipeline *Pipeline) Wait() {
+	pipeline.Overload.WaitReleased()
+	pipeline._outputsHub.Wait()
+}
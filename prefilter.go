@@ -0,0 +1,183 @@
+package main
+
+import (
+	"regexp/syntax"
+)
+
+// max number of alternative literals taken from one regex, regexes with more alternatives are always run
+const maxPrefilterLiterals = 16
+
+// LinePrefilter finds events of a rule which regexes can match the line, literals required by the regexes
+// are searched in one pass over the line by Aho-Corasick automaton, so regexes are run only for candidate lines
+type LinePrefilter struct {
+	_transitions [][256]int32
+	_outputs     [][]int
+	// events which regexes don't have required literals
+	_always []int
+	_events int
+}
+
+// NewLinePrefilter builds the automaton from literals of the event regexes
+func NewLinePrefilter(events []SecurityEventConfig) *LinePrefilter {
+	prefilter := &LinePrefilter{
+		_events: len(events),
+	}
+	prefilter._AddNode()
+
+	for event, eventConfig := range events {
+		literals, required := RequiredLiterals(eventConfig.Regex)
+		if eventConfig.CompiledDissect != nil {
+			literals, required = eventConfig.CompiledDissect.RequiredLiterals()
+		}
+		if !required {
+			prefilter._always = append(prefilter._always, event)
+			continue
+		}
+
+		for _, literal := range literals {
+			var node int32 = 0
+			for i := 0; i < len(literal); i++ {
+				next := prefilter._transitions[node][literal[i]]
+				if next == 0 {
+					next = prefilter._AddNode()
+					prefilter._transitions[node][literal[i]] = next
+				}
+				node = next
+			}
+			prefilter._outputs[node] = append(prefilter._outputs[node], event)
+		}
+	}
+
+	// link every node to the longest suffix in the trie and complete transitions, so search never goes back
+	fail := make([]int32, len(prefilter._transitions))
+	queue := make([]int32, 0)
+	for c := 0; c < 256; c++ {
+		if next := prefilter._transitions[0][c]; next != 0 {
+			queue = append(queue, next)
+		}
+	}
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		prefilter._outputs[node] = append(prefilter._outputs[node], prefilter._outputs[fail[node]]...)
+
+		for c := 0; c < 256; c++ {
+			next := prefilter._transitions[node][c]
+			if next != 0 {
+				fail[next] = prefilter._transitions[fail[node]][c]
+				queue = append(queue, next)
+			} else {
+				prefilter._transitions[node][c] = prefilter._transitions[fail[node]][c]
+			}
+		}
+	}
+
+	return prefilter
+}
+
+func (prefilter *LinePrefilter) _AddNode() int32 {
+	prefilter._transitions = append(prefilter._transitions, [256]int32{})
+	prefilter._outputs = append(prefilter._outputs, nil)
+	return int32(len(prefilter._transitions) - 1)
+}
+
+// Candidates marks events which regexes can match the line, the slice is reused when it's large enough
+func (prefilter *LinePrefilter) Candidates(line []byte, candidates []bool) []bool {
+	if cap(candidates) < prefilter._events {
+		candidates = make([]bool, prefilter._events)
+	}
+	candidates = candidates[:prefilter._events]
+	for i := range candidates {
+		candidates[i] = false
+	}
+
+	found := 0
+	for _, event := range prefilter._always {
+		candidates[event] = true
+		found++
+	}
+
+	var node int32 = 0
+	for i := 0; i < len(line) && found < prefilter._events; i++ {
+		node = prefilter._transitions[node][line[i]]
+
+		for _, event := range prefilter._outputs[node] {
+			if !candidates[event] {
+				candidates[event] = true
+				found++
+			}
+		}
+	}
+
+	return candidates
+}
+
+// RequiredLiterals returns literals one of which is contained in every text matched by the regex,
+// false is returned when there are no such literals
+func RequiredLiterals(regex string) ([]string, bool) {
+	parsed, err := syntax.Parse(regex, syntax.Perl)
+	if err != nil {
+		return nil, false
+	}
+
+	return requiredLiterals(parsed.Simplify())
+}
+
+func requiredLiterals(regex *syntax.Regexp) ([]string, bool) {
+	switch regex.Op {
+	case syntax.OpLiteral:
+		// case insensitive literals are not searched
+		if regex.Flags&syntax.FoldCase != 0 || len(regex.Rune) < 1 {
+			return nil, false
+		}
+		return []string{string(regex.Rune)}, true
+
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiterals(regex.Sub[0])
+
+	case syntax.OpRepeat:
+		if regex.Min > 0 {
+			return requiredLiterals(regex.Sub[0])
+		}
+
+	case syntax.OpConcat:
+		// the most selective literals are those with the longest shortest alternative
+		var best []string
+		for _, sub := range regex.Sub {
+			literals, required := requiredLiterals(sub)
+			if required && (best == nil || shortestLength(literals) > shortestLength(best)) {
+				best = literals
+			}
+		}
+		return best, best != nil
+
+	case syntax.OpAlternate:
+		alternatives := make([]string, 0)
+		for _, sub := range regex.Sub {
+			literals, required := requiredLiterals(sub)
+			if !required {
+				return nil, false
+			}
+			alternatives = append(alternatives, literals...)
+		}
+
+		if len(alternatives) > maxPrefilterLiterals {
+			return nil, false
+		}
+		return alternatives, true
+	}
+
+	return nil, false
+}
+
+func shortestLength(literals []string) int {
+	shortest := -1
+	for _, literal := range literals {
+		if shortest < 0 || len(literal) < shortest {
+			shortest = len(literal)
+		}
+	}
+	return shortest
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// lines matched by events of the shipped rules
+var shippedRuleSamples = map[string][]string{
+	"sshd": {
+		"Mar  3 10:15:42 web1 sshd[1234]: Accepted password for admin from 203.0.113.5 port 52144 ssh2",
+		"Mar  3 10:15:42 web1 sshd[1234]: Accepted publickey for deploy from 203.0.113.5 port 52144 ssh2: RSA SHA256:d1XxRm0V",
+		"Mar  3 10:15:42 web1 sshd[1234]: Failed password for invalid user oracle from 203.0.113.5 port 52144 ssh2",
+		"Mar  3 10:15:42 web1 sshd[1234]: Invalid user oracle from 203.0.113.5 port 52144",
+		"Mar  3 10:15:42 web1 sshd[1234]: Received disconnect from 203.0.113.5 port 40000:11: Bye Bye [preauth]",
+		"Mar  3 10:15:42 web1 sshd[1234]: ROOT LOGIN REFUSED FROM 203.0.113.5 port 40000",
+		"Mar  3 10:15:42 web1 sshd[1234]: User bob from 203.0.113.5 not allowed because not listed in AllowUsers",
+		"Mar  3 10:15:42 web1 sshd[1234]: pam_unix(sshd:auth): authentication failure; logname= uid=0 euid=0 tty=ssh ruser= rhost=203.0.113.5 user=root",
+		"Mar  3 10:15:42 web1 login[99]: pam_unix(login:session): session opened for user root by LOGIN(uid=0)",
+		"Mar  3 10:15:42 web1 login[99]: pam_unix(login:auth): authentication failure; logname=LOGIN uid=0 euid=0 tty=tty1 ruser= rhost=  user=root",
+	},
+	"installations": {
+		"Mar  3 10:15:42 web1 yum[2211]: Installed: nginx-1.20.1-1.el7.x86_64",
+		"Mar  3 10:15:42 web1 yum[2211]: Erased: httpd-2.4.6-97.el7.x86_64",
+	},
+	"fail2ban": {
+		"2026-03-03 10:15:42,123 fail2ban.filter         [811]: INFO    [sshd] Found 203.0.113.5",
+		"2026-03-03 10:15:42,123 fail2ban.actions        [811]: NOTICE  [sshd] Ban 203.0.113.5",
+	},
+	"openvpn": {
+		"Tue Mar 13 10:15:42 2026 203.0.113.5:1194 TLS: Username/Password authentication succeeded for username 'alice'",
+		"Tue Mar 13 10:15:42 2026 office/203.0.113.5:1194 SENT CONTROL [alice]: 'AUTH_FAILED' (status=1)",
+		"Tue Mar 13 10:15:42 2026 203.0.113.5:1194 WARNING: Failed running command (--auth-user-pass-verify): Auth Username/Password verification failed for peer",
+	},
+	"couchbase": {
+		`203.0.113.5 - Administrator [03/Mar/2026:10:15:42 +0000] "POST /uilogin HTTP/1.1" 200 0 - Mozilla/5.0 (X11; Linux x86_64) 12`,
+		`203.0.113.5 - Administrator [03/Mar/2026:10:15:42 +0000] "POST /uilogin HTTP/1.1" 400 0 - Mozilla/5.0 (X11; Linux x86_64) 12`,
+	},
+	"output-traffic-monitor": {
+		`2026/03/03 10:15:42 tcp 203.0.113.5:443 success:true pid:123 process:"curl" commandline:"curl https://example.com" timestamp:1772532942`,
+		`2026/03/03 10:15:42 udp 203.0.113.5:53 success:false bytes:64 pid:123 process:"dig" timestamp:1772532942`,
+	},
+	"pure-ftpd": {
+		"Mar  3 10:15:42 web1 pure-ftpd: (?@203.0.113.5) [INFO] alice is now logged in",
+		"Mar  3 10:15:42 web1 pure-ftpd: (?@203.0.113.5) [WARNING] Authentication failed for user [alice]",
+	},
+	"tcp-out": {
+		"Mar  3 10:15:42 web1 kernel: [123.456] OUT TCP: IN= OUT=eth0 SRC=10.0.0.2 DST=203.0.113.5 LEN=60 TOS=0x00 PREC=0x00 TTL=64 ID=1 DF PROTO=TCP SPT=40000 DPT=443 WINDOW=29200 RES=0x00 SYN URGP=0",
+	},
+	"wordpress-accesslog": {
+		`203.0.113.5 - - [03/Mar/2026:10:15:42 +0000] "POST /wp-login.php HTTP/1.1" 302 1042 "-" "Mozilla/5.0"`,
+		`example.com:443 203.0.113.5 - - [03/Mar/2026:10:15:42 +0000] "POST /wp-login.php HTTP/1.1" 200 4286 "-" "Mozilla/5.0"`,
+	},
+	"custom": {
+		`203.0.113.5 - - [03/Mar/2026:10:15:42 +0000] "POST /wp-login.php HTTP/1.1" 302 1042 "https://example.com/wp-admin/" "Mozilla/5.0"`,
+	},
+	"win_firewall_logs": {
+		"2026-03-03 10:15:42 ALLOW TCP 10.0.0.2 203.0.113.5 50000 443 0 - - - - - - - SEND",
+	},
+	"win_ftp": {
+		"2026-03-03 10:15:42 203.0.113.5 alice 10.0.0.2 21 PASS *** 530 1326 41 9b2f1b9e-4b0e-4d36-8d3a-0d5a8ae4b1f3 /",
+		"2026-03-03 10:15:42 203.0.113.5 alice 10.0.0.2 21 PASS *** 230 0 0 9b2f1b9e-4b0e-4d36-8d3a-0d5a8ae4b1f3 /",
+	},
+	"win_eventlog_security": {
+		"<EventData><Data Name='TargetUserName'>alice</Data><Data Name='IpAddress'>203.0.113.5</Data></EventData>",
+	},
+	"win_eventlog_terminal": {
+		`<UserData><EventXML><User>CORP\alice</User><Address>203.0.113.5</Address></EventXML></UserData>`,
+	},
+	"win_eventlog_application": {
+		"<EventData> <Data>nginx 1.20.1</Data></EventData>",
+	},
+	"win_eventlog_defender": {
+		"<EventData><Data Name='Current Signature Version'>1.381.2.0</Data></EventData>",
+		`<EventData><Data Name='Threat Name'>Trojan:Win32/Test</Data><Data Name='Path'>C:\tmp\x.exe</Data></EventData>`,
+	},
+	"win_eventlog_system": {
+		`<EventData><Data Name='ServiceName'>backdoor</Data><Data Name='ImagePath'>C:\tmp\svc.exe</Data></EventData>`,
+	},
+}
+
+// lines similar to events, but not matched by them
+var shippedRuleNearMisses = []string{
+	"Mar  3 10:15:42 web1 systemd[1]: Started Daily apt upgrade and clean activities.",
+	"Mar  3 10:15:42 web1 sshd[1234]: Connection closed by 203.0.113.5 port 52144",
+	"Mar  3 10:15:42 web1 sshd[1234]: pam_unix(sshd:session): session closed for user deploy",
+	"Mar  3 10:15:42 web1 CRON[99]: pam_unix(cron:session): session opened for user root by (uid=0)",
+	`203.0.113.5 - - [03/Mar/2026:10:15:42 +0000] "GET /wp-login.php HTTP/1.1" 200 4286 "-" "Mozilla/5.0"`,
+	"2026-03-03 10:15:42 DROP TCP 10.0.0.2 203.0.113.5 50000 443 0 - - - - - - - RECEIVE",
+	"",
+}
+
+func TestLinePrefilterShippedRules(t *testing.T) {
+	config := loadTestConfig(t)
+
+	lines := append([]string{}, shippedRuleNearMisses...)
+	for name, samples := range shippedRuleSamples {
+		rule := findTestRule(t, config, name)
+
+		for _, sample := range samples {
+			matched := false
+			for i := range rule.Events {
+				if rule.Events[i].Match([]byte(sample)) != nil {
+					matched = true
+				}
+			}
+			if !matched {
+				t.Errorf("rule '%s' does not match sample line %q", name, sample)
+			}
+		}
+
+		lines = append(lines, samples...)
+	}
+	lines = append(lines, generateAuthLog(200)...)
+	lines = append(lines, generateAccessLog(200)...)
+
+	// an event can be skipped only when its regex doesn't match the line
+	for r := range config.Input.RuleConfigs {
+		rule := &config.Input.RuleConfigs[r]
+
+		var candidates []bool
+		for _, line := range lines {
+			candidates = rule.Prefilter.Candidates([]byte(line), candidates)
+
+			for i := range rule.Events {
+				if !candidates[i] && rule.Events[i].Match([]byte(line)) != nil {
+					t.Errorf("prefilter of rule '%s' skips event sid %d (regex '%s') matching line %q", rule.RuleFileName, rule.Events[i].Sid, rule.Events[i].Regex, line)
+				}
+			}
+		}
+	}
+
+	// regexes are not run for lines without literals of the events
+	sshd := findTestRule(t, config, "sshd")
+	candidates := sshd.Prefilter.Candidates([]byte(shippedRuleNearMisses[0]), nil)
+	for i, candidate := range candidates {
+		if candidate {
+			t.Errorf("event sid %d (regex '%s') is a candidate for line %q", sshd.Events[i].Sid, sshd.Events[i].Regex, shippedRuleNearMisses[0])
+		}
+	}
+}
+
+func TestRequiredLiterals(t *testing.T) {
+	var manyAlternatives []string
+	for i := 0; i <= maxPrefilterLiterals; i++ {
+		manyAlternatives = append(manyAlternatives, fmt.Sprintf("%cword", 'a'+i))
+	}
+
+	tests := []struct {
+		regex    string
+		literals []string
+	}{
+		{`Failed password`, []string{"Failed password"}},
+		{`^(?P<ip>\S+) sshd.+?Failed password for`, []string{"Failed password for"}},
+		// case insensitive literals are not searched
+		{`(?i)failed password`, nil},
+		{`sshd(?i:failed password)`, []string{"sshd"}},
+		// one of alternatives is required, common prefixes are factored out by the parser
+		{`x(?:alpha|bravo|charlie)`, []string{"alpha", "bravo", "charlie"}},
+		{`Accepted (?:password|publickey) for`, []string{"Accepted "}},
+		{`abc|abd`, []string{"ab"}},
+		{`foo|\d+`, nil},
+		// optional groups are not required, even if they are longer
+		{`foo(barbaz)?qux`, []string{"foo"}},
+		{`(abc)?`, nil},
+		{`(?:abc){0,2}`, nil},
+		{`(?:abc){2}`, []string{"abc"}},
+		{`(?:abcd)+x`, []string{"abcd"}},
+		{`\d+`, nil},
+		{`(`, nil},
+		{strings.Join(manyAlternatives[:maxPrefilterLiterals], "|"), manyAlternatives[:maxPrefilterLiterals]},
+		{strings.Join(manyAlternatives, "|"), nil},
+	}
+
+	for _, test := range tests {
+		literals, required := RequiredLiterals(test.regex)
+		if required != (test.literals != nil) || (required && !reflect.DeepEqual(literals, test.literals)) {
+			t.Errorf("RequiredLiterals(%q) = %q, %v; expected %q", test.regex, literals, required, test.literals)
+		}
+	}
+}
+
+func TestLinePrefilterCandidates(t *testing.T) {
+	var manyAlternatives []string
+	for i := 0; i <= maxPrefilterLiterals; i++ {
+		manyAlternatives = append(manyAlternatives, fmt.Sprintf("%cword", 'a'+i))
+	}
+
+	dissect, err := CompileDissect(`%{ip} - %{user} [%{eventTime}] "POST /wp-login.php%{}" 302 %{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := []SecurityEventConfig{
+		{Regex: `(?i)failed password`},
+		{Regex: `(?:Accepted|Failed) password for`},
+		{Regex: `foo(barbaz)?qux`},
+		{Regex: strings.Join(manyAlternatives, "|")},
+		{CompiledDissect: dissect},
+		{Regex: `Received disconnect`},
+	}
+	prefilter := NewLinePrefilter(events)
+
+	tests := []struct {
+		line       string
+		candidates []bool
+	}{
+		// events without required literals are always candidates
+		{"nothing", []bool{true, false, false, true, false, false}},
+		{"FAILED PASSWORD for root", []bool{true, false, false, true, false, false}},
+		{"Failed password for root", []bool{true, true, false, true, false, false}},
+		{"Accepted password for root", []bool{true, true, false, true, false, false}},
+		{"fooqux", []bool{true, false, true, true, false, false}},
+		{"foobarbazqux", []bool{true, false, true, true, false, false}},
+		{`1.2.3.4 - - [x] "POST /wp-login.php HTTP/1.1" 302 10`, []bool{true, false, false, true, true, false}},
+		// literals are found at any position, also overlapping each other
+		{"Received disconnectFailed password for", []bool{true, true, false, true, false, true}},
+	}
+
+	var candidates []bool
+	for _, test := range tests {
+		candidates = prefilter.Candidates([]byte(test.line), candidates)
+		if !reflect.DeepEqual(candidates, test.candidates) {
+			t.Errorf("Candidates(%q) = %v; expected %v", test.line, candidates, test.candidates)
+		}
+	}
+}
+
+func BenchmarkParseLine(b *testing.B) {
+	config := loadTestConfig(b)
+	sshd := findTestRule(b, config, "sshd")
+
+	withoutPrefilter := *sshd
+	withoutPrefilter.Prefilter = nil
+
+	lines := make([][]byte, 0)
+	for _, line := range generateAuthLog(10000) {
+		lines = append(lines, []byte(line))
+	}
+
+	for _, benchmark := range []struct {
+		name string
+		rule *RuleConfig
+	}{
+		{"prefilter", sshd},
+		{"regex", &withoutPrefilter},
+	} {
+		b.Run(benchmark.name, func(b *testing.B) {
+			overload := &OverloadGuard{Config: &OverloadConfig{MemoryBudget: 1024 * 1024}}
+			overload.Config.Normalize()
+			overload.Init()
+
+			crawler := &FilesCrawler{Overload: overload}
+			source := "/var/log/auth.log"
+			eventsContainer := &SecurityEventsContainer{}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				crawler.ParseLine(&source, int64(i), lines[i%len(lines)], benchmark.rule, eventsContainer)
+
+				if len(eventsContainer.SecurityEvents) >= 1000 {
+					eventsContainer = &SecurityEventsContainer{}
+				}
+			}
+		})
+	}
+}
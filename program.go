@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"github.com/judwhite/go-svc/svc"
-	lumberjack "gopkg.in/natefinch/lumberjack.v2"
 	"log"
 	"net/http"
 	"path/filepath"
@@ -31,12 +30,7 @@ func (program *Program) Init(env svc.Environment) error {
 
 		CreateDirIfNotExist(filepath.Dir(options.LogFile), 0765)
 
-		log.SetOutput(&lumberjack.Logger{
-			Filename:   options.LogFile,
-			MaxSize:    50, // megabytes
-			MaxBackups: 3,
-			MaxAge:     28, //days
-		})
+		log.SetOutput(NewRotatingFile(options.LogFile, 0, 0, 0))
 	}
 
 	return nil
@@ -69,34 +63,27 @@ func (program *Program) InternalRun() {
 		return
 	}
 
-	// init all channels
-	systemState := &SystemState{
-		Input: make(chan []*SecurityEventsContainer),
+	err = ConfigureStateDirs(options)
+	if err != nil {
+		exit(exitStat.faulted, "Failed configuring state directories: %s", err)
+		return
 	}
-	systemState.Restore()
 
-	gate := &HttpGateway{
-		SystemState: systemState,
-		Options:     options,
-		MainConfig:  &config,
-		Input:       make(chan []*SecurityEventsContainer),
+	err = LockStateDir()
+	if err != nil {
+		exit(exitStat.faulted, "Failed locking state: %s", err)
+		return
 	}
-	gate.Init()
 
-	queue := &Queue{
-		Options:     options,
-		Input:       make(chan *SecurityEventsContainer),
-		NextChannel: gate.Input,
-	}
-	queue.Init()
+	MigrateLegacyStateDir()
 
-	ipEnricher := &IpEnricher{
-		Input:       make(chan *SecurityEventsContainer),
-		NextChannel: queue.Input,
-		Options:     options,
-		Config:      &config,
+	// init all channels
+	systemState := &SystemState{
+		Input: make(chan []*SecurityEventsContainer),
 	}
-	ipEnricher.Init()
+	systemState.Restore()
+
+	pipeline := NewPipeline(options, &config, systemState)
 
 	fileRules := make([]*RuleConfig, 0)
 	winEventRules := make([]*RuleConfig, 0)
@@ -111,17 +98,18 @@ func (program *Program) InternalRun() {
 	}
 
 	// run processing messages from channels
-	go systemState.Sync()
-	go ipEnricher.Run()
-	go queue.Run()
-	go gate.Run()
+	pipeline.Run()
 
 	// run crawler over files
 	if len(fileRules) > 0 {
 		filesCrawler := &FilesCrawler{
-			Rules:       fileRules,
-			SystemState: systemState,
-			NextChannel: ipEnricher.Input,
+			Rules:         fileRules,
+			SystemState:   systemState,
+			NextChannel:   pipeline.Input,
+			Overload:      pipeline.Overload,
+			ChunkEvents:   config.Input.ChunkEvents,
+			ChunkBytes:    int64(config.Input.ChunkSize) * 1024 * 1024,
+			MaxLineLength: config.Input.MaxLineSize * 1024,
 		}
 		filesCrawler.Init()
 		go filesCrawler.Run()
@@ -133,8 +121,9 @@ func (program *Program) InternalRun() {
 			winEventCrawler := &WinEventLogCrawler{
 				Rules:       winEventRules,
 				Options:     options,
-				NextChannel: ipEnricher.Input,
+				NextChannel: pipeline.Input,
 				SystemState: systemState,
+				Overload:    pipeline.Overload,
 			}
 			winEventCrawler.Init()
 			go winEventCrawler.Run()
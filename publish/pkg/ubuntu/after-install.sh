@@ -3,6 +3,7 @@
 chown -R dhound-agent:dhound-agent /opt/dhound-agent
 chown dhound-agent /var/log/dhound-agent
 chown dhound-agent:dhound-agent /var/lib/dhound-agent
+chmod 750 /var/lib/dhound-agent
 
 update-rc.d dhound-agent defaults
 
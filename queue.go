@@ -7,11 +7,16 @@ import (
 type Queue struct {
 	Input                   chan *SecurityEventsContainer
 	Options                 *Options
+	Config                  *BatchConfig
 	NextChannel             chan []*SecurityEventsContainer
+	CriticalChannel         chan []*SecurityEventsContainer
 	_items                  []*SecurityEventsContainer
 	_lastRun                time.Time
 	_maxItems               int
 	_maxSecurityEvents      int
+	_maxBytes               int
+	_totalSecurityEvents    int
+	_totalBytes             int
 	_containsCriticalEvent  bool
 	_idleTimeout            time.Duration
 	_idleTimeoutForCritical time.Duration
@@ -20,10 +25,11 @@ type Queue struct {
 
 func (queue *Queue) Init() {
 	queue._lastRun = time.Now()
-	queue._maxItems = 10
-	queue._maxSecurityEvents = 1000
-	queue._idleTimeout = queue.Options.IdleTimeout
-	queue._idleTimeoutForCritical = time.Second * 20
+	queue._maxItems = queue.Config.MaxItems
+	queue._maxSecurityEvents = queue.Config.MaxEvents
+	queue._maxBytes = queue.Config.MaxBytes
+	queue._idleTimeout = queue.Config.idleTimeout
+	queue._idleTimeoutForCritical = queue.Config.criticalTimeout
 	queue._containsCriticalEvent = false
 	queue._firstRun = true
 }
@@ -33,6 +39,8 @@ func (queue *Queue) Flush() {
 	queue.NextChannel <- itemsToSend
 
 	queue._items = nil
+	queue._totalSecurityEvents = 0
+	queue._totalBytes = 0
 	queue._containsCriticalEvent = false
 	queue._lastRun = time.Now()
 	queue._firstRun = false
@@ -59,8 +67,21 @@ func (queue *Queue) Run() {
 
 		// debugJson(eventsContainer)
 		if eventsContainer != nil {
+			// critical events don't wait for the batch, they are sent by the fast path
+			if queue.CriticalChannel != nil {
+				criticalContainer := eventsContainer.ExtractCriticalEvents()
+				if criticalContainer != nil {
+					queue.CriticalChannel <- []*SecurityEventsContainer{criticalContainer}
+				}
+			}
+
 			queue._items = append(queue._items, eventsContainer)
 
+			queue._totalSecurityEvents += len(eventsContainer.SecurityEvents)
+			for _, securityEvent := range eventsContainer.SecurityEvents {
+				queue._totalBytes += securityEvent.EstimatedJsonSize()
+			}
+
 			// check if eventsContainer contains critical event, if yes, it should be send on server faster as usual
 			if !queue._containsCriticalEvent {
 				for _, securityEvent := range eventsContainer.SecurityEvents {
@@ -87,16 +108,12 @@ func (queue *Queue) Run() {
 		} else if queue._firstRun {
 			// debug("first run after start. queue size: %d", len(queue._items))
 			queue.Flush()
-		} else {
-			totalEvents := 0
-			for _, eventsContainer := range queue._items {
-				totalEvents += len(eventsContainer.SecurityEvents)
-			}
-
-			if totalEvents >= queue._maxSecurityEvents {
-				// debug("FLUSH by max security events. size: %d, max: %d", totalEvents, queue._maxSecurityEvents)
-				queue.Flush()
-			}
+		} else if queue._totalSecurityEvents >= queue._maxSecurityEvents {
+			// debug("FLUSH by max security events. size: %d, max: %d", queue._totalSecurityEvents, queue._maxSecurityEvents)
+			queue.Flush()
+		} else if queue._totalBytes >= queue._maxBytes {
+			// debug("FLUSH by max bytes. size: %d, max: %d", queue._totalBytes, queue._maxBytes)
+			queue.Flush()
 		}
 	}
 }
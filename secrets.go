@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const secretCommandTimeout = 30 * time.Second
+
+// only explicit ${env:NAME} references are expanded in config files, so regexes with '$' stay untouched
+var envReferenceRegex = regexp.MustCompile(`\$\{env:([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+func ExpandEnvReferences(content []byte) []byte {
+	return envReferenceRegex.ReplaceAllFunc(content, func(reference []byte) []byte {
+		name := envReferenceRegex.FindSubmatch(reference)[1]
+		return []byte(os.Getenv(string(name)))
+	})
+}
+
+// ResolveSecrets loads access token and server key from files or credential helpers when they are configured so
+func ResolveSecrets(config *OutputConfig) error {
+	var err error
+
+	config.AccessToken, err = ResolveSecret("accesstoken", config.AccessToken, config.AccessTokenFile, config.AccessTokenCmd)
+	if err != nil {
+		return err
+	}
+
+	config.ServerKey, err = ResolveSecret("serverkey", config.ServerKey, config.ServerKeyFile, config.ServerKeyCmd)
+	return err
+}
+
+func ResolveSecret(name string, value string, file string, command string) (string, error) {
+	specified := 0
+	for _, option := range []string{value, file, command} {
+		if len(option) > 0 {
+			specified++
+		}
+	}
+
+	if specified > 1 {
+		return "", errors.New(fmt.Sprintf("only one of %s, %s_file and %s_cmd can be specified", name, name, name))
+	}
+
+	if len(file) > 0 {
+		return ReadSecretFile(file)
+	}
+
+	if len(command) > 0 {
+		return RunSecretCommand(command)
+	}
+
+	return value, nil
+}
+
+// ReadSecretFile reads the secret from the file, which must not be accessible by group or others
+func ReadSecretFile(path string) (string, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	err = CheckSecretFilePermissions(path, fileInfo)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	secret := strings.TrimSpace(string(content))
+	if len(secret) < 1 {
+		return "", errors.New(fmt.Sprintf("secret file '%s' is empty", path))
+	}
+
+	return secret, nil
+}
+
+// RunSecretCommand runs the credential helper (without shell) and takes the secret from its output
+func RunSecretCommand(command string) (string, error) {
+	args := strings.Fields(command)
+	if len(args) < 1 {
+		return "", errors.New("credential helper command is empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("credential helper '%s' failed: %s %s", args[0], err, strings.TrimSpace(stderr.String())))
+	}
+
+	secret := strings.TrimSpace(stdout.String())
+	if len(secret) < 1 {
+		return "", errors.New(fmt.Sprintf("credential helper '%s' returned empty output", args[0]))
+	}
+
+	return secret, nil
+}
@@ -9,4 +9,20 @@ type SecurityEvent struct {
 	AdditionalFields   map[string]string `json:"a,omitempty"`
 	Critical           bool              `json:"-"`
 	Source             *string           `json:"src,omitempty"`
+	Sequence           uint64            `json:"seq,omitempty"`
+}
+
+// EstimatedJsonSize returns approximate size of the event in a request to the collector
+func (securityEvent *SecurityEvent) EstimatedJsonSize() int {
+	size := 80 + len(securityEvent.Message) + len(securityEvent.IpAddress)
+
+	if securityEvent.Source != nil {
+		size += len(*securityEvent.Source)
+	}
+
+	for key, value := range securityEvent.AdditionalFields {
+		size += len(key) + len(value) + 6
+	}
+
+	return size
 }
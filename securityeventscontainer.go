@@ -8,8 +8,59 @@ type SecurityEventsContainer struct {
 
 	IpToServiceMap map[string][]string `json:"ipmap,omitempty"`
 	SecurityEvents []*SecurityEvent    `json:"events,omitempty"`
+
+	// memory reserved in the overload guard until the container is committed
+	_reservedBytes int64
+	// reserved memory by names of rules, so the guard knows which rules overload the agent
+	_reservedRules map[string]int64
+	// closed when critical events extracted from the container are sent or spooled by the fast path
+	_criticalSent chan struct{}
 }
 
 func (container *SecurityEventsContainer) CleanSecurityEventsFromDublicates() {
 	container.SecurityEvents = CleanSecurityEventsFromDublicates(container.SecurityEvents)
 }
+
+// ExtractCriticalEvents moves critical events to a new container without source id,
+// the offset of the source is committed together with the rest of its events after the critical events are sent
+func (container *SecurityEventsContainer) ExtractCriticalEvents() *SecurityEventsContainer {
+	var criticalEvents []*SecurityEvent
+	var otherEvents []*SecurityEvent
+
+	for _, securityEvent := range container.SecurityEvents {
+		if securityEvent.Critical {
+			criticalEvents = append(criticalEvents, securityEvent)
+		} else {
+			otherEvents = append(otherEvents, securityEvent)
+		}
+	}
+
+	if len(criticalEvents) < 1 {
+		return nil
+	}
+
+	container.SecurityEvents = otherEvents
+	container._criticalSent = make(chan struct{})
+
+	return &SecurityEventsContainer{
+		Source:         container.Source,
+		IpToServiceMap: container.IpToServiceMap,
+		SecurityEvents: criticalEvents,
+		_criticalSent:  container._criticalSent,
+	}
+}
+
+// CriticalSent is called for the container of extracted critical events when they are sent or spooled
+func (container *SecurityEventsContainer) CriticalSent() {
+	if container._criticalSent != nil {
+		close(container._criticalSent)
+		container._criticalSent = nil
+	}
+}
+
+// WaitCriticalSent blocks until critical events extracted from the container are sent or spooled
+func (container *SecurityEventsContainer) WaitCriticalSent() {
+	if container._criticalSent != nil {
+		<-container._criticalSent
+	}
+}
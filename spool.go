@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Spool keeps request bodies which failed to be delivered, to resend them later in the original order
+type Spool struct {
+	Dir    string
+	Prefix string
+}
+
+func (spool *Spool) Save(content []byte) error {
+	CreateDirIfNotExist(spool.Dir, StateDirMode)
+
+	spoolFile := filepath.Join(spool.Dir, fmt.Sprintf("%s%d", spool._Prefix(), time.Now().UnixNano()))
+	return ioutil.WriteFile(spoolFile, content, 0600)
+}
+
+// Files returns spooled files from the oldest to the newest
+func (spool *Spool) Files() []string {
+	files, _ := filepath.Glob(filepath.Join(spool.Dir, spool._Prefix()+"*"))
+	sort.Strings(files)
+	return files
+}
+
+// _Prefix returns the prefix of spooled files in the namespace of the process
+func (spool *Spool) _Prefix() string {
+	if len(SpoolNamespace) > 0 {
+		return "." + SpoolNamespace + spool.Prefix
+	}
+	return spool.Prefix
+}
+
+func (spool *Spool) Remove(spoolFile string) {
+	err := os.Remove(spoolFile)
+	if err != nil {
+		emitLine(logLevel.important, "failed removing spool file %s, error: %s", spoolFile, err)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+// RunStateCommand lists and changes offsets of the sources, the agent should be stopped
+func RunStateCommand(options *Options, args []string) error {
+	if len(args) < 1 {
+		return errors.New("state command is not specified")
+	}
+
+	err := ConfigureStateDirs(options)
+	if err != nil {
+		return err
+	}
+
+	err = LockStateDir()
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s, stop the agent before changing the state", err))
+	}
+
+	// list and export only read the state, so they don't move files of the legacy directory
+	readOnly := args[0] == "list" || args[0] == "export"
+	if readOnly {
+		if IsFileExists(legacyStateDir) && !isSameDir(legacyStateDir, StateDir) && !IsFileExists(SystemStateFileName()) {
+			emitLine(logLevel.important, "legacy state directory '%s' is not migrated yet, it's migrated by the agent or changing commands", legacyStateDir)
+		}
+	} else {
+		MigrateLegacyStateDir()
+
+		// backfill reserves sequence numbers in the state file also while the agent is stopped,
+		// so the file is changed under its lock from reading to saving
+		stateFileLock := lockStateFile()
+		defer stateFileLock.Close()
+	}
+
+	state := &SystemState{}
+	state.ReadOriginalState()
+
+	switch args[0] {
+	case "list":
+		return state.List(os.Stdout)
+	case "reset":
+		if len(args) != 2 {
+			return errors.New("state reset requires the source path or id")
+		}
+		return state.Reset(args[1])
+	case "rewind":
+		return state.RewindCommand(options, args[1:])
+	case "export":
+		if len(args) > 2 {
+			return errors.New("state export accepts only the file name")
+		}
+		output := io.Writer(os.Stdout)
+		if len(args) == 2 {
+			file, err := os.OpenFile(args[1], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			output = file
+		}
+		return state.Export(output)
+	case "import":
+		if len(args) != 2 {
+			return errors.New("state import requires the file name")
+		}
+		return state.Import(args[1])
+	}
+
+	return errors.New(fmt.Sprintf("unknown state command '%s'", args[0]))
+}
+
+// List prints sources with the lag in bytes between the offset and the end of the file
+func (state *SystemState) List(output io.Writer) error {
+	writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "SOURCE\tID\tOFFSET\tLINE\tUPDATED\tLAG")
+
+	for _, sourceState := range state.Sources {
+		lag := "-"
+		if GetFileOsUniqueKey(sourceState.Source) == sourceState.SourceId {
+			fileInfo, err := os.Stat(sourceState.Source)
+			if err == nil {
+				lag = fmt.Sprintf("%d", fileInfo.Size()-sourceState.Offset)
+			}
+		}
+
+		updated := "-"
+		if sourceState.LastUpdatedTimeUtcNumber > 0 {
+			updated = CustomLongToTime(sourceState.LastUpdatedTimeUtcNumber).Local().Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%d\t%s\t%s\n", sourceState.Source, sourceState.SourceId, sourceState.Offset, sourceState.Line, updated, lag)
+	}
+
+	return writer.Flush()
+}
+
+// Reset moves the offset of the source to the beginning, the source is found by path or id
+func (state *SystemState) Reset(pathOrId string) error {
+	sourceStates := state._FindByPathOrId(pathOrId)
+	if len(sourceStates) < 1 {
+		return errors.New(fmt.Sprintf("source '%s' is not found in the state", pathOrId))
+	}
+
+	for _, sourceState := range sourceStates {
+		sourceState.Offset = 0
+		sourceState.Line = 0
+		sourceState.LastUpdatedTimeUtcNumber = DateToCustomLong(time.Now())
+		fmt.Printf("%s (%s) is reset\n", sourceState.Source, sourceState.SourceId)
+	}
+
+	state.Save()
+	return nil
+}
+
+func (state *SystemState) RewindCommand(options *Options, args []string) error {
+	flagSet := flag.NewFlagSet("rewind", flag.ContinueOnError)
+	since := flagSet.String("since", "", "time of the first event to process again, RFC3339 or duration")
+
+	paths, err := parseCommandArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+
+	if len(paths) != 1 || len(*since) < 1 {
+		return errors.New("state rewind requires the file path and --since option")
+	}
+
+	sinceTime, err := ParseSinceTime(*since)
+	if err != nil {
+		return err
+	}
+
+	config, err := LoadConfig(options)
+	if err != nil {
+		return errors.New("failed loading config files")
+	}
+
+	return state.Rewind(paths[0], sinceTime, config.Input.RuleConfigs)
+}
+
+// Rewind moves the offset of the file to the first line with an event since the time,
+// time of the lines is parsed by rules configured for the file
+func (state *SystemState) Rewind(path string, since time.Time, ruleConfigs []RuleConfig) error {
+	path, err := filepath.Abs(NormalizeFileName(path))
+	if err != nil {
+		return err
+	}
+
+	rules := make([]*RuleConfig, 0)
+	for i := range ruleConfigs {
+		for _, rulePath := range ruleConfigs[i].Paths {
+			matched, _ := filepath.Match(NormalizeFileName(rulePath), path)
+			if matched {
+				rules = append(rules, &ruleConfigs[i])
+				break
+			}
+		}
+	}
+
+	if len(rules) < 1 {
+		return errors.New(fmt.Sprintf("no rules are configured for the file '%s'", path))
+	}
+
+	sourceId := GetFileOsUniqueKey(path)
+	if len(sourceId) < 1 {
+		return errors.New(fmt.Sprintf("file '%s' is not found", path))
+	}
+
+	offset, line, err := FindOffsetSince(path, since, rules)
+	if err != nil {
+		return err
+	}
+
+	sourceState := state.Find(sourceId)
+	sourceState.Source = path
+	sourceState.Offset = offset
+	sourceState.Line = line
+	sourceState.LastUpdatedTimeUtcNumber = DateToCustomLong(time.Now())
+
+	state.Save()
+
+	fmt.Printf("%s (%s) is rewound to offset %d, line %d\n", path, sourceId, offset, line)
+	return nil
+}
+
+// Export writes the state as formatted json
+func (state *SystemState) Export(output io.Writer) error {
+	state.Version = SystemStateSchemaVersion
+
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = output.Write(append(content, '\n'))
+	return err
+}
+
+// Import replaces sources by the exported state, the sequence is never moved back,
+// so numbers of events already sent are not reused
+func (state *SystemState) Import(fileName string) error {
+	importedState := &SystemState{}
+	err := importedState._ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	state.Sources = importedState.Sources
+	if importedState.Sequence > state.Sequence {
+		state.Sequence = importedState.Sequence
+	}
+
+	state.Save()
+
+	fmt.Printf("%d sources are imported from %s\n", len(state.Sources), fileName)
+	return nil
+}
+
+func (state *SystemState) _FindByPathOrId(pathOrId string) []*SourceState {
+	sourceStates := make([]*SourceState, 0)
+	path, _ := filepath.Abs(NormalizeFileName(pathOrId))
+
+	for _, sourceState := range state.Sources {
+		if sourceState.SourceId == pathOrId || sourceState.Source == path {
+			sourceStates = append(sourceStates, sourceState)
+		}
+	}
+
+	return sourceStates
+}
+
+// ParseSinceTime parses RFC3339 time, local date and time, or duration back from now
+func ParseSinceTime(value string) (time.Time, error) {
+	duration, err := time.ParseDuration(value)
+	if err == nil {
+		return time.Now().Add(-duration), nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		since, err := time.ParseInLocation(layout, value, time.Local)
+		if err == nil {
+			return since, nil
+		}
+	}
+
+	return time.Time{}, errors.New(fmt.Sprintf("incorrect time '%s', expected RFC3339 time, date or duration", value))
+}
+
+// LineEventTime returns time of the first event of the rules found in the line
+func LineEventTime(line []byte, rules []*RuleConfig) (time.Time, bool) {
+	var candidates []bool
+	for _, rule := range rules {
+		// regexes are run only for events which required literals are found in the line
+		if rule.Prefilter != nil {
+			candidates = rule.Prefilter.Candidates(line, candidates)
+		}
+
+		for i := range rule.Events {
+			if rule.Prefilter != nil && !candidates[i] {
+				continue
+			}
+
+			resultMap := rule.Events[i].Match(line)
+			if resultMap == nil {
+				continue
+			}
+
+			eventTime, err := rule.ParseEventTime(resultMap["eventTime"])
+			if err == nil {
+				return eventTime, true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runTestStateCommand runs the state command and releases the lock of the state directory held by it
+func runTestStateCommand(options *Options, args ...string) error {
+	err := RunStateCommand(options, args)
+	if systemStateLock != nil {
+		systemStateLock.Close()
+		systemStateLock = nil
+	}
+	return err
+}
+
+func readTestState(t *testing.T, stateFile string) *SystemState {
+	state := &SystemState{}
+	if err := state._ReadFile(stateFile); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func testStateJson(state *SystemState) string {
+	content, _ := json.Marshal(state)
+	return string(content)
+}
+
+func TestStateCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state_command")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the legacy state directory is in the working directory
+	workingDir, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(workingDir)
+	stateDir, spoolDir := StateDir, SpoolDir
+	defer func() { StateDir, SpoolDir = stateDir, spoolDir }()
+
+	os.Mkdir(legacyStateDir, 0750)
+	legacyState := `{"v":1,"s":[{"srcid":"1_2","src":"/var/log/auth.log","offset":100,"line":5,"t":` + I64toa(DateToCustomLong(time.Now())) + `}],"seq":5}`
+	writeTestLog(t, legacyStateDir, ".dhound-state", legacyState)
+
+	options := &Options{ConfigDir: "config", StateDir: filepath.Join(dir, "state")}
+
+	// list and export don't move the legacy state
+	exported := filepath.Join(dir, "exported.json")
+	for _, args := range [][]string{{"list"}, {"export", exported}} {
+		if err := runTestStateCommand(options, args...); err != nil {
+			t.Fatalf("state %s failed: %s", args[0], err)
+		}
+	}
+	// the state directory is configured by the command
+	stateFile := SystemStateFileName()
+	if !IsFileExists(filepath.Join(legacyStateDir, ".dhound-state")) || IsFileExists(stateFile) {
+		t.Errorf("legacy state is migrated by list or export")
+	}
+
+	if err := runTestStateCommand(options, "reset", "1_2"); err != nil {
+		t.Fatal(err)
+	}
+	if IsFileExists(filepath.Join(legacyStateDir, ".dhound-state")) {
+		t.Errorf("legacy state is not migrated by reset")
+	}
+	state := readTestState(t, stateFile)
+	if len(state.Sources) != 1 || state.Sources[0].Offset != 0 || state.Sources[0].Line != 0 || state.Sequence != 5 {
+		t.Errorf("state after reset is %s", testStateJson(state))
+	}
+
+	// the exported state is imported back, the sequence isn't moved back
+	if err := runTestStateCommand(options, "export", exported); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadFile(exported)
+	content = bytes.Replace(content, []byte(`"offset": 0`), []byte(`"offset": 777`), 1)
+	content = bytes.Replace(content, []byte(`"seq": 5`), []byte(`"seq": 3`), 1)
+	ioutil.WriteFile(exported, content, 0640)
+
+	if err := runTestStateCommand(options, "import", exported); err != nil {
+		t.Fatal(err)
+	}
+	state = readTestState(t, stateFile)
+	if len(state.Sources) != 1 || state.Sources[0].Offset != 777 || state.Sequence != 5 {
+		t.Errorf("state after import is %s", testStateJson(state))
+	}
+
+	incorrect := [][]string{{"reset", "/var/log/missing.log"}, {"reset"}, {"import", filepath.Join(dir, "missing.json")}, {"export", "a", "b"}, {"forget"}}
+	for _, args := range incorrect {
+		if err := runTestStateCommand(options, args...); err == nil {
+			t.Errorf("state %v doesn't fail", args)
+		}
+	}
+
+	// the state isn't changed while backfill holds the lock of the state file
+	stateFileLock := lockStateFile()
+	done := make(chan error)
+	go func() {
+		done <- runTestStateCommand(options, "reset", "1_2")
+	}()
+
+	select {
+	case <-done:
+		t.Errorf("state is reset while the state file is locked")
+	case <-time.After(200 * time.Millisecond):
+		if offset := readTestState(t, stateFile).Sources[0].Offset; offset != 777 {
+			t.Errorf("offset is %d while the state file is locked", offset)
+		}
+		stateFileLock.Close()
+		<-done
+	}
+	if offset := readTestState(t, stateFile).Sources[0].Offset; offset != 0 {
+		t.Errorf("offset is %d after reset, expected 0", offset)
+	}
+
+	// the state can't be changed while the agent is running
+	LockStateDir()
+	err = runTestStateCommand(options, "reset", "1_2")
+	if err == nil || !strings.Contains(err.Error(), "stop the agent") {
+		t.Errorf("state is changed while the agent is running: %v", err)
+	}
+}
+
+func TestStateListRewind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state_command")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, restore := useTestStateDir(t)
+	defer restore()
+
+	start := time.Now().Add(-2 * time.Hour).Truncate(time.Minute)
+	lines := syslogLines(start, time.Minute, 100)
+	path := writeTestLog(t, dir, "auth.log", strings.Join(lines, "\n")+"\n")
+
+	rule := *findTestRule(t, loadTestConfig(t), "sshd")
+	rule.Paths = []string{filepath.Join(dir, "*.log")}
+
+	state := &SystemState{}
+	if err := state.Rewind(path, start.Add(30*time.Minute), []RuleConfig{rule}); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Rewind(filepath.Join(dir, "other.txt"), start, []RuleConfig{rule}); err == nil {
+		t.Errorf("file without rules is rewound")
+	}
+
+	state = readTestState(t, SystemStateFileName())
+	if len(state.Sources) != 1 || state.Sources[0].Offset != lineOffset(lines, 30) || state.Sources[0].Line != 31 {
+		t.Fatalf("state after rewind is %s, expected offset %d", testStateJson(state), lineOffset(lines, 30))
+	}
+
+	// the lag is the rest of the file after the offset, it's not known for files replaced since
+	state.Sources = append(state.Sources, &SourceState{SourceId: "1_2", Source: path})
+	var output bytes.Buffer
+	if err := state.List(&output); err != nil {
+		t.Fatal(err)
+	}
+
+	listed := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(listed) != 3 || strings.Fields(listed[0])[5] != "LAG" {
+		t.Fatalf("listed state is\n%s", output.String())
+	}
+	size := int64(len(strings.Join(lines, "\n")) + 1)
+	for i, expected := range []string{I64toa(size - lineOffset(lines, 30)), "-"} {
+		fields := strings.Fields(listed[i+1])
+		if fields[0] != path || fields[len(fields)-1] != expected {
+			t.Errorf("listed source %q, expected lag %s", listed[i+1], expected)
+		}
+	}
+
+	var exported SystemState
+	output.Reset()
+	state.Export(&output)
+	if err := json.Unmarshal(output.Bytes(), &exported); err != nil || len(exported.Sources) != 2 || exported.Version != SystemStateSchemaVersion {
+		t.Errorf("exported state is %s (%v)", output.String(), err)
+	}
+}
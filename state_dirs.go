@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// directories of the source state and of spooled requests, relative to the working directory by default
+var StateDir = ".state"
+var SpoolDir = ".state"
+
+// state directory used by previous versions of the agent
+const legacyStateDir = ".state"
+
+const StateDirMode os.FileMode = 0750
+
+// namespace of spooled requests of a process sharing the spool directory with the agent, like backfill,
+// requests of the namespace are replayed only by processes of the same namespace
+var SpoolNamespace = ""
+
+// prefixes of spooled requests, they are moved to the spool directory on migration
+var spoolFilePrefixes = []string{".net_", ".critical_", ".splunk_"}
+
+func SystemStateFileName() string {
+	return filepath.Join(StateDir, ".dhound-state")
+}
+
+// ConfigureStateDirs sets the state and spool directories from options or the main config, options take precedence,
+// the spool directory is the state directory by default
+func ConfigureStateDirs(options *Options) error {
+	config := MainConfig{}
+
+	// secrets are not needed here, so the main config file is read without resolving them
+	mainConfig := path.Join(options.ConfigDir, "config.yml")
+	if IsFileExists(mainConfig) {
+		err := LoadYamlFile(mainConfig, &config)
+		if err != nil {
+			return errors.New(fmt.Sprintf("failed loading main config file '%s': %s", mainConfig, err))
+		}
+	}
+
+	StateDir = firstNotEmpty(options.StateDir, config.StateDir, legacyStateDir)
+	SpoolDir = firstNotEmpty(options.SpoolDir, config.SpoolDir, StateDir)
+
+	dirs := []string{StateDir}
+	if !isSameDir(SpoolDir, StateDir) {
+		dirs = append(dirs, SpoolDir)
+	}
+
+	for _, dir := range dirs {
+		existed := IsFileExists(dir)
+
+		err := CreateDirIfNotExist(dir, StateDirMode)
+		if err != nil {
+			return errors.New(fmt.Sprintf("failed creating directory '%s': %s", dir, err))
+		}
+
+		// only directories created by the agent are tightened, existing ones can be shared, like /tmp
+		if existed {
+			err = CheckDirPermissions(dir)
+		} else {
+			err = FixDirPermissions(dir, StateDirMode)
+		}
+		if err != nil {
+			return errors.New(fmt.Sprintf("failed checking permissions of directory '%s': %s", dir, err))
+		}
+	}
+
+	return nil
+}
+
+// MigrateLegacyStateDir moves files of the .state directory in the working directory to the configured directories,
+// files already existing in the new place are not replaced
+func MigrateLegacyStateDir() {
+	if !IsFileExists(legacyStateDir) || (isSameDir(legacyStateDir, StateDir) && isSameDir(legacyStateDir, SpoolDir)) {
+		return
+	}
+
+	// the legacy directory can be still used by another agent
+	legacyLock, err := LockFile(filepath.Join(legacyStateDir, ".lock"))
+	if err != nil {
+		emitLine(logLevel.important, "legacy state directory '%s' is used by another agent, it's not migrated", legacyStateDir)
+		return
+	}
+
+	files, err := ioutil.ReadDir(legacyStateDir)
+	if err != nil {
+		legacyLock.Close()
+		emitLine(logLevel.important, "failed reading legacy state directory '%s': %s", legacyStateDir, err)
+		return
+	}
+
+	migrated := 0
+	for _, file := range files {
+		if file.IsDir() || file.Name() == ".lock" {
+			continue
+		}
+
+		targetDir := StateDir
+		for _, prefix := range spoolFilePrefixes {
+			if strings.HasPrefix(file.Name(), prefix) {
+				targetDir = SpoolDir
+			}
+		}
+
+		if isSameDir(legacyStateDir, targetDir) {
+			continue
+		}
+
+		source := filepath.Join(legacyStateDir, file.Name())
+		target := filepath.Join(targetDir, file.Name())
+		if IsFileExists(target) {
+			emitLine(logLevel.important, "'%s' is not migrated, '%s' already exists", source, target)
+			continue
+		}
+
+		err = MoveFile(source, target)
+		if err != nil {
+			emitLine(logLevel.important, "failed migrating '%s' to '%s': %s", source, target, err)
+			continue
+		}
+		migrated++
+	}
+
+	if migrated > 0 {
+		emitLine(logLevel.important, "%d files are migrated from '%s' to state directory '%s' and spool directory '%s'", migrated, legacyStateDir, StateDir, SpoolDir)
+	}
+
+	// remove the legacy directory if only the lock file is left
+	legacyLock.Close()
+	os.Remove(filepath.Join(legacyStateDir, ".lock"))
+	os.Remove(legacyStateDir)
+}
+
+// MoveFile renames the file, it's copied when the target is on another device
+func MoveFile(source string, target string) error {
+	err := os.Rename(source, target)
+	if err == nil {
+		return nil
+	}
+
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	fileInfo, err := sourceFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	targetFile, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fileInfo.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(targetFile, sourceFile)
+	if err == nil {
+		err = targetFile.Sync()
+	}
+
+	closeErr := targetFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(target)
+		return err
+	}
+
+	sourceFile.Close()
+	return os.Remove(source)
+}
+
+func isSameDir(dir1 string, dir2 string) bool {
+	abs1, err1 := filepath.Abs(dir1)
+	abs2, err2 := filepath.Abs(dir2)
+
+	return err1 == nil && err2 == nil && abs1 == abs2
+}
+
+func firstNotEmpty(values ...string) string {
+	for _, value := range values {
+		if len(value) > 0 {
+			return value
+		}
+	}
+	return ""
+}
@@ -2,30 +2,68 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sync"
 	"time"
 )
 
-const SystemStateFileName string = ".state/.dhound-state"
+// version of the state file format, increased on incompatible changes
+const SystemStateSchemaVersion int = 1
 
 type SystemState struct {
-	Sources []*SourceState                  `json:"s"`
-	Input   chan []*SecurityEventsContainer `json:"-"`
+	Version int            `json:"v,omitempty"`
+	Sources []*SourceState `json:"s"`
+	// the last sequence number assigned to a security event sent from this host
+	Sequence uint64                          `json:"seq,omitempty"`
+	Input    chan []*SecurityEventsContainer `json:"-"`
+	// ephemeral state is kept only in memory, it's used by backfill to not touch offsets of the agent
+	Ephemeral bool `json:"-"`
+	// offsets committed by the pipeline to the ephemeral state, crawlers keep their own offsets in Sources
+	_committed *SystemState
+	// sources are found and added by crawlers of several goroutines
+	_sourcesMutex sync.Mutex
+	// the next sequence number and the last one of the block reserved in the state file
+	_sequenceNext  uint64
+	_sequenceLimit uint64
+	_sequenceMutex sync.Mutex
 }
 
+// count of sequence numbers reserved in the state file at once
+const sequenceBlockSize = 1000
+
+// the state file is read and written by several goroutines
+var systemStateFileMutex sync.Mutex
+
+// corrupted state file is not moved to the backup, so the good backup generation is kept
+var systemStateFileCorrupted bool
+
 func (state *SystemState) Sync() {
 
 	for eventsContainers := range state.Input {
 		if len(eventsContainers) > 0 {
 
-			originalState := &SystemState{}
-			originalState.ReadOriginalState()
+			systemStateFileMutex.Lock()
+
+			var stateFileLock *os.File
+			if !state.Ephemeral {
+				stateFileLock = lockStateFile()
+			}
+
+			originalState := state._Original()
 
 			for _, eventsContainer := range eventsContainers {
 
 				sourceId := (*eventsContainer).SourceId
 
+				// events of the agent itself don't have a source to track
+				if len(sourceId) < 1 {
+					continue
+				}
+
 				sourceState := originalState.Find(sourceId)
 
 				sourceState.Offset = eventsContainer.Offset
@@ -36,39 +74,152 @@ func (state *SystemState) Sync() {
 			}
 
 			originalState.Save()
+
+			if stateFileLock != nil {
+				stateFileLock.Close()
+			}
+			systemStateFileMutex.Unlock()
 		}
 	}
 }
 
+// ReserveSequence returns the first of count sequence numbers for new events,
+// numbers are never reused after restart, so the server can detect duplicates and lost events,
+// the numbers are reserved in the state file also for the ephemeral state, as backfill sends events of the same host
+func (state *SystemState) ReserveSequence(count uint64) uint64 {
+	state._sequenceMutex.Lock()
+	defer state._sequenceMutex.Unlock()
+
+	if state._sequenceNext == 0 || state._sequenceNext+count-1 > state._sequenceLimit {
+		state._ReserveSequenceBlock(count)
+	}
+
+	first := state._sequenceNext
+	state._sequenceNext += count
+	return first
+}
+
+// _ReserveSequenceBlock persists the upper bound of the next block of sequence numbers, so the state file is written
+// once per block instead of every batch, numbers of the block not used before restart are skipped
+func (state *SystemState) _ReserveSequenceBlock(count uint64) {
+	systemStateFileMutex.Lock()
+	defer systemStateFileMutex.Unlock()
+
+	stateFileLock := lockStateFile()
+	defer stateFileLock.Close()
+
+	originalState := &SystemState{}
+	originalState.ReadOriginalState()
+
+	// the block is continued when no other process has reserved numbers after it
+	if state._sequenceNext == 0 || originalState.Sequence != state._sequenceLimit {
+		state._sequenceNext = originalState.Sequence + 1
+	}
+
+	size := uint64(sequenceBlockSize)
+	if count > size {
+		size = count
+	}
+	originalState.Sequence = state._sequenceNext + size - 1
+	originalState.Save()
+
+	state._sequenceLimit = originalState.Sequence
+}
+
+// Save writes the state to a temp file and renames it over the state file, so a crash never leaves a partial file,
+// the previous state file is kept as a backup generation
 func (state *SystemState) Save() {
 
-	CreateDirIfNotExist(filepath.Dir(SystemStateFileName), 0664)
+	if state.Ephemeral {
+		return
+	}
+
+	CreateDirIfNotExist(StateDir, StateDirMode)
+
+	state.Version = SystemStateSchemaVersion
+
+	backup := SystemStateFileName() + ".bak"
+	if systemStateFileCorrupted {
+		backup = ""
+	}
 
 	content, _ := json.Marshal(state)
-	err := ioutil.WriteFile(SystemStateFileName, content, 0664)
+	err := WriteFileAtomic(SystemStateFileName(), backup, content, 0640)
 	if err != nil {
-		emitLine(logLevel.important, "Failed to save state of the sources (%s).", SystemStateFileName, err.Error())
+		emitLine(logLevel.important, "Failed to save state of the sources (%s): %s", SystemStateFileName(), err.Error())
+		return
+	}
+
+	systemStateFileCorrupted = false
+}
+
+// _Original returns the state saved in the file, the committed copy is returned for the ephemeral state as it's not saved,
+// so the pipeline doesn't share sources with crawlers
+func (state *SystemState) _Original() *SystemState {
+	if state.Ephemeral {
+		if state._committed == nil {
+			state._committed = &SystemState{Ephemeral: true}
+		}
+		return state._committed
 	}
+
+	originalState := &SystemState{}
+	originalState.ReadOriginalState()
+	return originalState
 }
 
+// ReadOriginalState reads the state file, the backup generation is used when the state file is missing or corrupted
 func (state *SystemState) ReadOriginalState() {
-	if IsFileExists(SystemStateFileName) {
-		content, err := ioutil.ReadFile(SystemStateFileName)
+	stateFiles := []string{SystemStateFileName(), SystemStateFileName() + ".bak"}
+
+	for i, stateFile := range stateFiles {
+		if !IsFileExists(stateFile) {
+			continue
+		}
+
+		err := state._ReadFile(stateFile)
 		if err != nil {
-			emitLine(logLevel.important, "failed read system state file %s, error: %s", SystemStateFileName, err.Error())
-		} else {
-			err := json.Unmarshal(content, &state)
-			if err != nil {
-				emitLine(logLevel.important, "failed converting content to Json from system state file %s. error: %s, Content: %s", SystemStateFileName, err.Error(), content)
+			emitLine(logLevel.important, "failed reading system state file %s, error: %s", stateFile, err.Error())
+			if i == 0 {
+				systemStateFileCorrupted = true
 			}
+			continue
+		}
+
+		if i > 0 {
+			emitLine(logLevel.important, "system state is recovered from the backup file %s", stateFile)
 		}
 
 		state.RemoveExpiredStates()
+		break
 	}
 
 	// debugJson(state)
 }
 
+func (state *SystemState) _ReadFile(stateFile string) error {
+	content, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		return err
+	}
+
+	readState := SystemState{}
+	err = json.Unmarshal(content, &readState)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed converting content to Json: %s, Content: %s", err.Error(), content))
+	}
+
+	if readState.Version > SystemStateSchemaVersion {
+		return errors.New(fmt.Sprintf("state file version %d is not supported by this agent, the latest known version is %d", readState.Version, SystemStateSchemaVersion))
+	}
+
+	state.Version = readState.Version
+	state.Sources = readState.Sources
+	state.Sequence = readState.Sequence
+
+	return nil
+}
+
 func (state *SystemState) RemoveExpiredStates() {
 
 	// max 30 days to keep state source
@@ -86,12 +237,62 @@ func (state *SystemState) RemoveExpiredStates() {
 	state.Sources = sourceList
 }
 
+// lockStateFile waits for the lock of the state file, it's taken by processes sharing the state directory,
+// like the agent and backfill, for reading and saving the file
+func lockStateFile() *os.File {
+	lockFileName := filepath.Join(StateDir, ".state.lock")
+	CreateDirIfNotExist(StateDir, StateDirMode)
+
+	for attempt := 0; ; attempt++ {
+		lockFile, err := LockFile(lockFileName)
+		if err == nil {
+			return lockFile
+		}
+
+		if attempt == 500 {
+			emitLine(logLevel.important, "waiting for lock of the state file '%s': %s", lockFileName, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// the lock of the state directory is held for the life of the process
+var systemStateLock *os.File
+
+// LockStateDir prevents two agents from using the same state directory,
+// the lock file contains the process id of the agent holding it
+func LockStateDir() error {
+	lockFileName := filepath.Join(StateDir, ".lock")
+
+	CreateDirIfNotExist(StateDir, StateDirMode)
+
+	lockFile, err := LockFile(lockFileName)
+	if err != nil {
+		pid, _ := ioutil.ReadFile(lockFileName)
+		if len(pid) > 0 {
+			return errors.New(fmt.Sprintf("state directory '%s' is used by another agent (pid %s)", filepath.Dir(lockFileName), pid))
+		}
+		return errors.New(fmt.Sprintf("state directory '%s' is used by another agent: %s", filepath.Dir(lockFileName), err))
+	}
+
+	lockFile.Truncate(0)
+	lockFile.WriteString(fmt.Sprintf("%d", os.Getpid()))
+	lockFile.Sync()
+
+	systemStateLock = lockFile
+
+	return nil
+}
+
 func (state *SystemState) Restore() {
-	CreateDirIfNotExist(".state", 0765)
+	CreateDirIfNotExist(StateDir, StateDirMode)
 	state.ReadOriginalState()
 }
 
 func (state *SystemState) Find(sourceId string) *SourceState {
+	state._sourcesMutex.Lock()
+	defer state._sourcesMutex.Unlock()
+
 	for _, sourceState := range state.Sources {
 		if sourceId == sourceState.SourceId {
 			return sourceState
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useTestStateDir points the state directory to a temp one, the returned function restores it
+func useTestStateDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stateDir, spoolDir := StateDir, SpoolDir
+	StateDir, SpoolDir = dir, dir
+	return dir, func() {
+		StateDir, SpoolDir = stateDir, spoolDir
+		os.RemoveAll(dir)
+	}
+}
+
+func readTestSequence() uint64 {
+	state := &SystemState{}
+	state.ReadOriginalState()
+	return state.Sequence
+}
+
+// sequence numbers are reserved by the agent and backfill in blocks, so the state file isn't written by every batch
+func TestReserveSequence(t *testing.T) {
+	_, restore := useTestStateDir(t)
+	defer restore()
+
+	agent := &SystemState{}
+	if first := agent.ReserveSequence(10); first != 1 {
+		t.Errorf("first sequence is %d, expected 1", first)
+	}
+	if first := agent.ReserveSequence(5); first != 11 {
+		t.Errorf("next sequence is %d, expected 11", first)
+	}
+	if sequence := readTestSequence(); sequence != sequenceBlockSize {
+		t.Errorf("reserved sequence is %d, expected %d", sequence, sequenceBlockSize)
+	}
+
+	// the block is continued while no other process reserves numbers
+	agent.ReserveSequence(sequenceBlockSize - 15)
+	if first := agent.ReserveSequence(1); first != sequenceBlockSize+1 {
+		t.Errorf("sequence after the block is %d, expected %d", first, sequenceBlockSize+1)
+	}
+
+	// numbers of backfill follow the block of the agent, the agent skips them in its next block
+	backfill := &SystemState{Ephemeral: true}
+	if first := backfill.ReserveSequence(3000); first != 2*sequenceBlockSize+1 {
+		t.Errorf("backfill sequence is %d, expected %d", first, 2*sequenceBlockSize+1)
+	}
+	agent.ReserveSequence(sequenceBlockSize - 1)
+	if first := agent.ReserveSequence(1); first != 5*sequenceBlockSize+1 {
+		t.Errorf("agent sequence after backfill is %d, expected %d", first, 5*sequenceBlockSize+1)
+	}
+
+	// numbers not used before restart are skipped
+	restarted := &SystemState{}
+	if first := restarted.ReserveSequence(1); first != 6*sequenceBlockSize+1 {
+		t.Errorf("sequence after restart is %d, expected %d", first, 6*sequenceBlockSize+1)
+	}
+}
+
+func TestReserveSequenceConcurrently(t *testing.T) {
+	_, restore := useTestStateDir(t)
+	defer restore()
+
+	states := []*SystemState{{}, {Ephemeral: true}}
+	reserved := make([][]uint64, 8)
+
+	var wait sync.WaitGroup
+	for i := range reserved {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			for j := 0; j < 300; j++ {
+				reserved[i] = append(reserved[i], states[i%2].ReserveSequence(7))
+			}
+		}(i)
+	}
+	wait.Wait()
+
+	used := make(map[uint64]bool)
+	for _, firsts := range reserved {
+		for _, first := range firsts {
+			for sequence := first; sequence < first+7; sequence++ {
+				if used[sequence] {
+					t.Fatalf("sequence %d is reserved twice", sequence)
+				}
+				used[sequence] = true
+			}
+		}
+	}
+}
+
+func testSourceState(offset int64) *SystemState {
+	return &SystemState{Sources: []*SourceState{{SourceId: "1_2", Source: "/var/log/auth.log", Offset: offset, LastUpdatedTimeUtcNumber: DateToCustomLong(time.Now())}}}
+}
+
+func readTestOffset(t *testing.T, path string) int64 {
+	state := &SystemState{}
+	if err := state._ReadFile(path); err != nil {
+		t.Fatalf("failed reading state file '%s': %s", path, err)
+	}
+	return state.Sources[0].Offset
+}
+
+// the state file is replaced by the new one, the previous generation is kept as the backup
+func TestSystemStateSave(t *testing.T) {
+	dir, restore := useTestStateDir(t)
+	defer restore()
+
+	testSourceState(100).Save()
+	testSourceState(200).Save()
+
+	if offset := readTestOffset(t, SystemStateFileName()); offset != 200 {
+		t.Errorf("offset of the state file is %d, expected 200", offset)
+	}
+	if offset := readTestOffset(t, SystemStateFileName()+".bak"); offset != 100 {
+		t.Errorf("offset of the backup is %d, expected 100", offset)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(files) > 0 {
+		t.Errorf("temp files %v are left", files)
+	}
+
+	// the replaced file isn't changed when the new one can't be written
+	err := WriteFileAtomic(filepath.Join(dir, "missing", "state"), "", []byte("{}"), 0640)
+	if err == nil {
+		t.Errorf("file is written to the missing directory")
+	}
+	os.Mkdir(SystemStateFileName()+".tmp", 0750)
+	err = WriteFileAtomic(SystemStateFileName(), SystemStateFileName()+".bak", []byte("{}"), 0640)
+	if err == nil {
+		t.Errorf("file is written over the directory")
+	}
+	if offset := readTestOffset(t, SystemStateFileName()); offset != 200 {
+		t.Errorf("offset of the state file is %d after failed write, expected 200", offset)
+	}
+}
+
+// the backup is read when the state file is corrupted, missing or written by a newer agent,
+// the good backup isn't replaced by the corrupted file
+func TestSystemStateBackup(t *testing.T) {
+	_, restore := useTestStateDir(t)
+	defer restore()
+	defer func() { systemStateFileCorrupted = false }()
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"corrupted", `{"s":[{"srcid":"1_2","offs`},
+		{"newer version", `{"v":100,"s":[]}`},
+		{"missing", ""},
+	}
+
+	for _, test := range tests {
+		testSourceState(100).Save()
+		testSourceState(200).Save()
+
+		os.Remove(SystemStateFileName())
+		if len(test.content) > 0 {
+			ioutil.WriteFile(SystemStateFileName(), []byte(test.content), 0640)
+		}
+
+		state := &SystemState{}
+		state.ReadOriginalState()
+		if len(state.Sources) != 1 || state.Sources[0].Offset != 100 {
+			t.Errorf("%s: state is not recovered from the backup: %v", test.name, state.Sources)
+			continue
+		}
+
+		state.Sources[0].Offset = 300
+		state.Save()
+
+		if offset := readTestOffset(t, SystemStateFileName()); offset != 300 {
+			t.Errorf("%s: offset of the state file is %d, expected 300", test.name, offset)
+		}
+		if offset := readTestOffset(t, SystemStateFileName()+".bak"); offset != 100 {
+			t.Errorf("%s: offset of the backup is %d, expected 100", test.name, offset)
+		}
+		os.Remove(SystemStateFileName() + ".bak")
+	}
+}
+
+func TestLockStateDir(t *testing.T) {
+	_, restore := useTestStateDir(t)
+	defer restore()
+
+	if err := LockStateDir(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		systemStateLock.Close()
+		systemStateLock = nil
+	}()
+
+	err := LockStateDir()
+	if err == nil || !strings.Contains(err.Error(), "used by another agent (pid "+Itoa(os.Getpid())+")") {
+		t.Errorf("state directory is locked twice: %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TlsConfig struct {
+	// client certificate and key (PEM) for mutual TLS
+	ClientCert string `json:"clientcert,omitempty" yaml:"clientcert,omitempty"`
+	ClientKey  string `json:"clientkey,omitempty" yaml:"clientkey,omitempty"`
+	// CA certificates (PEM) to verify the collector instead of the system roots
+	CaBundle string `json:"cabundle,omitempty" yaml:"cabundle,omitempty"`
+	// 1.0, 1.1, 1.2 (default) or 1.3
+	MinVersion string `json:"minversion,omitempty" yaml:"minversion,omitempty"`
+	// base64 sha256 hashes of the subject public key info (the same as curl --pinnedpubkey 'sha256//...')
+	PinnedKeys []string `json:"pinnedkeys,omitempty" yaml:"pinnedkeys,omitempty"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+const tlsCertificateExpirationWarning = 14 * 24 * time.Hour
+
+// LoadCaBundle reads PEM encoded certificates to verify a server certificate
+func LoadCaBundle(path string) (*x509.CertPool, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, errors.New(fmt.Sprintf("no PEM certificates found in '%s'", path))
+	}
+
+	return pool, nil
+}
+
+// TlsFiles keeps certificates loaded from the files of TlsConfig and reloads them when the files are changed
+type TlsFiles struct {
+	Config           *TlsConfig
+	_clientCert      *tls.Certificate
+	_caPool          *x509.CertPool
+	_pins            map[string]bool
+	_filesState      string
+	_mutex           sync.Mutex
+	_lastReloadError string
+}
+
+// NewTlsClientConfig returns tls settings for https connections to the collector
+func NewTlsClientConfig(config *TlsConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config == nil {
+		return tlsConfig, nil
+	}
+
+	if len(config.MinVersion) > 0 {
+		version, found := tlsVersions[config.MinVersion]
+		if !found {
+			return nil, errors.New(fmt.Sprintf("tls version '%s' is not supported, use 1.0, 1.1, 1.2 or 1.3", config.MinVersion))
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if (len(config.ClientCert) > 0) != (len(config.ClientKey) > 0) {
+		return nil, errors.New("both clientcert and clientkey should be specified")
+	}
+
+	files := &TlsFiles{Config: config}
+	err := files.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(config.ClientCert) > 0 {
+		tlsConfig.GetClientCertificate = files.GetClientCertificate
+	}
+
+	if len(config.CaBundle) > 0 {
+		// the chain is verified in VerifyConnection by the current (reloadable) CA bundle
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	if len(config.CaBundle) > 0 || len(config.PinnedKeys) > 0 {
+		tlsConfig.VerifyConnection = files.VerifyConnection
+	}
+
+	return tlsConfig, nil
+}
+
+// Load reads all files, it fails on missing, incorrect or expired certificates
+func (files *TlsFiles) Load() error {
+	config := files.Config
+
+	if len(config.ClientCert) > 0 {
+		certificate, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return errors.New(fmt.Sprintf("failed loading client certificate '%s' with key '%s': %s", config.ClientCert, config.ClientKey, err))
+		}
+
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			return errors.New(fmt.Sprintf("failed parsing client certificate '%s': %s", config.ClientCert, err))
+		}
+
+		now := time.Now()
+		if now.After(leaf.NotAfter) {
+			return errors.New(fmt.Sprintf("client certificate '%s' (%s) expired on %s", config.ClientCert, leaf.Subject, leaf.NotAfter.Format(time.RFC3339)))
+		}
+		if now.Before(leaf.NotBefore) {
+			return errors.New(fmt.Sprintf("client certificate '%s' (%s) is not valid before %s", config.ClientCert, leaf.Subject, leaf.NotBefore.Format(time.RFC3339)))
+		}
+		if leaf.NotAfter.Sub(now) < tlsCertificateExpirationWarning {
+			emitLine(logLevel.important, "client certificate '%s' (%s) expires on %s.", config.ClientCert, leaf.Subject, leaf.NotAfter.Format(time.RFC3339))
+		}
+
+		certificate.Leaf = leaf
+		files._clientCert = &certificate
+	}
+
+	if len(config.CaBundle) > 0 {
+		pool, err := LoadCaBundle(config.CaBundle)
+		if err != nil {
+			return errors.New(fmt.Sprintf("failed loading CA bundle: %s", err))
+		}
+		files._caPool = pool
+	}
+
+	if len(config.PinnedKeys) > 0 {
+		files._pins = make(map[string]bool)
+		for _, pin := range config.PinnedKeys {
+			pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256//")
+			hash, err := base64.StdEncoding.DecodeString(pin)
+			if err != nil || len(hash) != sha256.Size {
+				return errors.New(fmt.Sprintf("pinned key '%s' is not a base64 sha256 hash", pin))
+			}
+			files._pins[string(hash)] = true
+		}
+	}
+
+	files._filesState = files._FilesState()
+	return nil
+}
+
+// _ReloadIfChanged loads the files again if any of them is modified, previous certificates are kept on errors
+func (files *TlsFiles) _ReloadIfChanged() {
+	files._mutex.Lock()
+	defer files._mutex.Unlock()
+
+	filesState := files._FilesState()
+	if filesState == files._filesState {
+		return
+	}
+
+	reloaded := &TlsFiles{Config: files.Config}
+	err := reloaded.Load()
+	if err != nil {
+		if err.Error() != files._lastReloadError {
+			emitLine(logLevel.important, "failed reloading tls certificates, previous ones are used. error: %s", err)
+			files._lastReloadError = err.Error()
+		}
+		return
+	}
+
+	emitLine(logLevel.important, "tls certificates are reloaded.")
+	files._clientCert = reloaded._clientCert
+	files._caPool = reloaded._caPool
+	files._pins = reloaded._pins
+	files._filesState = reloaded._filesState
+	files._lastReloadError = ""
+}
+
+func (files *TlsFiles) _FilesState() string {
+	state := ""
+	for _, path := range []string{files.Config.ClientCert, files.Config.ClientKey, files.Config.CaBundle} {
+		if len(path) < 1 {
+			continue
+		}
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			state += path + ":-;"
+			continue
+		}
+		state += fmt.Sprintf("%s:%d:%d;", path, fileInfo.Size(), fileInfo.ModTime().UnixNano())
+	}
+	return state
+}
+
+func (files *TlsFiles) GetClientCertificate(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	files._ReloadIfChanged()
+
+	files._mutex.Lock()
+	certificate := files._clientCert
+	files._mutex.Unlock()
+
+	if time.Now().After(certificate.Leaf.NotAfter) {
+		return nil, errors.New(fmt.Sprintf("client certificate '%s' (%s) expired on %s", files.Config.ClientCert, certificate.Leaf.Subject, certificate.Leaf.NotAfter.Format(time.RFC3339)))
+	}
+
+	return certificate, nil
+}
+
+// VerifyConnection verifies the collector certificate by the CA bundle and checks the pinned keys
+func (files *TlsFiles) VerifyConnection(state tls.ConnectionState) error {
+	files._ReloadIfChanged()
+
+	files._mutex.Lock()
+	caPool := files._caPool
+	pins := files._pins
+	files._mutex.Unlock()
+
+	if len(state.PeerCertificates) < 1 {
+		return errors.New("collector did not present a certificate")
+	}
+
+	chains := state.VerifiedChains
+	if caPool != nil {
+		options := x509.VerifyOptions{
+			DNSName:       state.ServerName,
+			Roots:         caPool,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, certificate := range state.PeerCertificates[1:] {
+			options.Intermediates.AddCert(certificate)
+		}
+
+		var err error
+		chains, err = state.PeerCertificates[0].Verify(options)
+		if err != nil {
+			return errors.New(fmt.Sprintf("collector certificate (%s) is not trusted by CA bundle '%s': %s", state.PeerCertificates[0].Subject, files.Config.CaBundle, err))
+		}
+	}
+
+	if len(pins) < 1 {
+		return nil
+	}
+
+	for _, chain := range chains {
+		for _, certificate := range chain {
+			hash := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+			if pins[string(hash[:])] {
+				return nil
+			}
+		}
+	}
+
+	leafHash := sha256.Sum256(state.PeerCertificates[0].RawSubjectPublicKeyInfo)
+	return errors.New(fmt.Sprintf("collector certificate (%s) does not match pinned keys, its key is sha256//%s", state.PeerCertificates[0].Subject, base64.StdEncoding.EncodeToString(leafHash[:])))
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertificate is a certificate with its key, the certificate is signed by itself when it's a CA
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	pem         []byte
+	keyPem      []byte
+}
+
+var testCertificateSerial int64
+
+func newTestCertificate(t *testing.T, name string, ca *testCertificate, notAfter time.Time) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCertificateSerial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(testCertificateSerial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	parent, parentKey := template, key
+	if ca == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		parent, parentKey = ca.certificate, ca.key
+	}
+
+	content, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, _ := x509.ParseCertificate(content)
+	keyContent, _ := x509.MarshalECPrivateKey(key)
+
+	return &testCertificate{
+		certificate: certificate,
+		key:         key,
+		pem:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: content}),
+		keyPem:      pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyContent}),
+	}
+}
+
+// pin returns the pinned key of the certificate in the curl format
+func (certificate *testCertificate) pin() string {
+	hash := sha256.Sum256(certificate.certificate.RawSubjectPublicKeyInfo)
+	return "sha256//" + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// writeTestFile replaces the file and moves its modification time, so the change is seen within the time resolution
+func writeTestFile(t *testing.T, path string, content []byte) {
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Now().Add(time.Duration(testCertificateSerial) * time.Second)
+	os.Chtimes(path, modified, modified)
+}
+
+// newTestTlsServer returns the https server with the certificate, it requires client certificates signed by the client CA
+// and answers with the name of the client certificate
+func newTestTlsServer(serverCertificate *testCertificate, clientCa *testCertificate) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte(request.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+
+	certificate, _ := tls.X509KeyPair(serverCertificate.pem, serverCertificate.keyPem)
+	clientCas := x509.NewCertPool()
+	clientCas.AddCert(clientCa.certificate)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCas,
+	}
+	server.StartTLS()
+	return server
+}
+
+// getTestTls requests the server by a new connection and returns the name of the client certificate seen by the server
+func getTestTls(tlsConfig *tls.Config, url string) (string, error) {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, DisableKeepAlives: true}}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	return string(content), err
+}
+
+func TestTlsClientConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	notAfter := time.Now().Add(365 * 24 * time.Hour)
+	serverCa := newTestCertificate(t, "server ca", nil, notAfter)
+	otherCa := newTestCertificate(t, "other ca", nil, notAfter)
+	clientCa := newTestCertificate(t, "client ca", nil, notAfter)
+	serverCertificate := newTestCertificate(t, "collector", serverCa, notAfter)
+
+	server := newTestTlsServer(serverCertificate, clientCa)
+	defer server.Close()
+
+	config := &TlsConfig{
+		ClientCert: filepath.Join(dir, "client.pem"),
+		ClientKey:  filepath.Join(dir, "client.key"),
+		CaBundle:   filepath.Join(dir, "ca.pem"),
+		PinnedKeys: []string{serverCa.pin()},
+	}
+	client1 := newTestCertificate(t, "client 1", clientCa, notAfter)
+	writeTestFile(t, config.ClientCert, client1.pem)
+	writeTestFile(t, config.ClientKey, client1.keyPem)
+	writeTestFile(t, config.CaBundle, append(otherCa.pem, serverCa.pem...))
+
+	tlsConfig, err := NewTlsClientConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := getTestTls(tlsConfig, server.URL)
+	if err != nil || name != "client 1" {
+		t.Fatalf("collector is requested with certificate '%s': %v", name, err)
+	}
+
+	// the client certificate is reloaded after it's renewed
+	client2 := newTestCertificate(t, "client 2", clientCa, notAfter)
+	writeTestFile(t, config.ClientCert, client2.pem)
+	writeTestFile(t, config.ClientKey, client2.keyPem)
+	if name, err := getTestTls(tlsConfig, server.URL); err != nil || name != "client 2" {
+		t.Errorf("collector is requested with certificate '%s' after renewal: %v", name, err)
+	}
+
+	// the previous certificate is used while the new files are broken
+	writeTestFile(t, config.ClientCert, []byte("broken"))
+	if name, err := getTestTls(tlsConfig, server.URL); err != nil || name != "client 2" {
+		t.Errorf("collector is requested with certificate '%s' while the files are broken: %v", name, err)
+	}
+	writeTestFile(t, config.ClientCert, client2.pem)
+
+	// the collector isn't trusted after the CA bundle is changed
+	writeTestFile(t, config.CaBundle, otherCa.pem)
+	if _, err := getTestTls(tlsConfig, server.URL); err == nil || !strings.Contains(err.Error(), "not trusted by CA bundle") {
+		t.Errorf("collector is trusted by the reloaded CA bundle: %v", err)
+	}
+	writeTestFile(t, config.CaBundle, serverCa.pem)
+	if _, err := getTestTls(tlsConfig, server.URL); err != nil {
+		t.Errorf("collector is not trusted by the reloaded CA bundle: %s", err)
+	}
+}
+
+func TestTlsPinnedKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	notAfter := time.Now().Add(365 * 24 * time.Hour)
+	serverCa := newTestCertificate(t, "server ca", nil, notAfter)
+	clientCa := newTestCertificate(t, "client ca", nil, notAfter)
+	serverCertificate := newTestCertificate(t, "collector", serverCa, notAfter)
+	otherCertificate := newTestCertificate(t, "other", serverCa, notAfter)
+	client := newTestCertificate(t, "client", clientCa, notAfter)
+
+	server := newTestTlsServer(serverCertificate, clientCa)
+	defer server.Close()
+
+	caBundle := filepath.Join(dir, "ca.pem")
+	writeTestFile(t, caBundle, serverCa.pem)
+	writeTestFile(t, filepath.Join(dir, "client.pem"), client.pem)
+	writeTestFile(t, filepath.Join(dir, "client.key"), client.keyPem)
+
+	tests := []struct {
+		name    string
+		pins    []string
+		matches bool
+	}{
+		{"collector key", []string{serverCertificate.pin()}, true},
+		{"CA key", []string{otherCertificate.pin(), serverCa.pin()}, true},
+		{"pin without prefix", []string{strings.TrimPrefix(serverCertificate.pin(), "sha256//")}, true},
+		{"other key", []string{otherCertificate.pin()}, false},
+	}
+
+	for _, test := range tests {
+		tlsConfig, err := NewTlsClientConfig(&TlsConfig{
+			ClientCert: filepath.Join(dir, "client.pem"),
+			ClientKey:  filepath.Join(dir, "client.key"),
+			CaBundle:   caBundle,
+			PinnedKeys: test.pins,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = getTestTls(tlsConfig, server.URL)
+		if test.matches && err != nil {
+			t.Errorf("%s: collector is not requested: %s", test.name, err)
+		} else if !test.matches && (err == nil || !strings.Contains(err.Error(), "does not match pinned keys, its key is "+serverCertificate.pin())) {
+			t.Errorf("%s: collector is requested without matching pins: %v", test.name, err)
+		}
+	}
+
+	incorrect := []*TlsConfig{
+		{PinnedKeys: []string{"sha256//not a hash"}},
+		{PinnedKeys: []string{base64.StdEncoding.EncodeToString([]byte("short"))}},
+		{MinVersion: "1.4"},
+		{ClientCert: filepath.Join(dir, "client.pem")},
+		{CaBundle: filepath.Join(dir, "missing.pem")},
+	}
+	for _, config := range incorrect {
+		if _, err := NewTlsClientConfig(config); err == nil {
+			t.Errorf("incorrect tls config %+v is accepted", config)
+		}
+	}
+
+	// expired client certificates are not loaded
+	expired := newTestCertificate(t, "expired", clientCa, time.Now().Add(-time.Minute))
+	writeTestFile(t, filepath.Join(dir, "expired.pem"), expired.pem)
+	writeTestFile(t, filepath.Join(dir, "expired.key"), expired.keyPem)
+	_, err = NewTlsClientConfig(&TlsConfig{ClientCert: filepath.Join(dir, "expired.pem"), ClientKey: filepath.Join(dir, "expired.key")})
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expired client certificate is loaded: %v", err)
+	}
+}
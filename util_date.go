@@ -74,15 +74,23 @@ func replace(in string) (out string) {
 	return
 }
 
-// smart format date, if year is not specified it will be calculated automatically
+// smart format date, if year is not specified it will be calculated automatically,
+// dates later than tomorrow are of the previous year, e.g. December lines read in January
 func ExYearParseDate(format string, value string, loc *time.Location) (time.Time, error) {
-	if strings.Contains(format, "Y") == false {
-		format = "YYYY " + format
-		timeUtc := time.Now().UTC()
-		value = Itoa(timeUtc.Year()) + " " + value
+	return exYearParseDate(format, value, loc, time.Now())
+}
+
+// exYearParseDate takes the year of the current time in the location of the date
+func exYearParseDate(format string, value string, loc *time.Location, now time.Time) (time.Time, error) {
+	if strings.Contains(format, "Y") {
+		return time.ParseInLocation(replace(format), value, loc)
 	}
 
-	return time.ParseInLocation(replace(format), value, loc)
+	date, err := time.ParseInLocation(replace("YYYY "+format), Itoa(now.In(loc).Year())+" "+value, loc)
+	if err == nil && date.After(now.Add(24*time.Hour)) {
+		date = date.AddDate(-1, 0, 0)
+	}
+	return date, err
 }
 
 var (
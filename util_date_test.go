@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExYearParseDate(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	newYork := time.FixedZone("EST", -5*60*60)
+
+	tests := []struct {
+		name     string
+		format   string
+		value    string
+		loc      *time.Location
+		now      time.Time
+		expected time.Time
+	}{
+		{"current year", "MMM D hh:mm:ss", "Jun 10 08:00:00", time.UTC,
+			time.Date(2026, time.June, 10, 12, 0, 0, 0, time.UTC), time.Date(2026, time.June, 10, 8, 0, 0, 0, time.UTC)},
+		// clocks of hosts differ a bit, so a date a few hours ahead is of the current year
+		{"few hours ahead", "MMM D hh:mm:ss", "Jun 10 20:00:00", time.UTC,
+			time.Date(2026, time.June, 10, 12, 0, 0, 0, time.UTC), time.Date(2026, time.June, 10, 20, 0, 0, 0, time.UTC)},
+		{"later than tomorrow", "MMM D hh:mm:ss", "Jun 12 08:00:00", time.UTC,
+			time.Date(2026, time.June, 10, 12, 0, 0, 0, time.UTC), time.Date(2025, time.June, 12, 8, 0, 0, 0, time.UTC)},
+		{"december in january", "MMM D hh:mm:ss", "Dec 31 23:59:59", time.UTC,
+			time.Date(2027, time.January, 1, 0, 5, 0, 0, time.UTC), time.Date(2026, time.December, 31, 23, 59, 59, 0, time.UTC)},
+		{"january in january", "MMM D hh:mm:ss", "Jan  1 00:01:00", time.UTC,
+			time.Date(2027, time.January, 1, 0, 5, 0, 0, time.UTC), time.Date(2027, time.January, 1, 0, 1, 0, 0, time.UTC)},
+		{"december in december", "MMM D hh:mm:ss", "Dec 31 23:59:59", time.UTC,
+			time.Date(2026, time.December, 31, 23, 59, 59, 0, time.UTC), time.Date(2026, time.December, 31, 23, 59, 59, 0, time.UTC)},
+		// the new year has come in the location of the date, but not in UTC yet
+		{"new year east of utc", "MMM D hh:mm:ss", "Jan  1 00:10:00", moscow,
+			time.Date(2026, time.December, 31, 21, 30, 0, 0, time.UTC), time.Date(2027, time.January, 1, 0, 10, 0, 0, moscow)},
+		{"old year east of utc", "MMM D hh:mm:ss", "Dec 31 23:50:00", moscow,
+			time.Date(2026, time.December, 31, 21, 30, 0, 0, time.UTC), time.Date(2026, time.December, 31, 23, 50, 0, 0, moscow)},
+		// the new year has come in UTC, but not in the location of the date
+		{"old year west of utc", "MMM D hh:mm:ss", "Dec 31 21:00:00", newYork,
+			time.Date(2027, time.January, 1, 3, 0, 0, 0, time.UTC), time.Date(2026, time.December, 31, 21, 0, 0, 0, newYork)},
+		{"january west of utc", "MMM D hh:mm:ss", "Jan  1 00:00:00", newYork,
+			time.Date(2027, time.January, 1, 3, 0, 0, 0, time.UTC), time.Date(2026, time.January, 1, 0, 0, 0, 0, newYork)},
+		// dates with the year are not changed
+		{"year", "YYYY-MM-DD hh:mm:ss", "2027-06-12 08:00:00", time.UTC,
+			time.Date(2026, time.June, 10, 12, 0, 0, 0, time.UTC), time.Date(2027, time.June, 12, 8, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		date, err := exYearParseDate(test.format, test.value, test.loc, test.now)
+		if err != nil {
+			t.Errorf("%s: failed parsing '%s': %s", test.name, test.value, err)
+			continue
+		}
+
+		if !date.Equal(test.expected) {
+			t.Errorf("%s: '%s' at %s is parsed as %s, expected %s", test.name, test.value, test.now.Format(time.RFC3339), date.Format(time.RFC3339), test.expected.Format(time.RFC3339))
+		}
+	}
+
+	if _, err := exYearParseDate("MMM D hh:mm:ss", "Jun 31 08:00:00", time.UTC, time.Now()); err == nil {
+		t.Errorf("incorrect date is parsed")
+	}
+}
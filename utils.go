@@ -1,8 +1,12 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
 	"regexp"
 	"strings"
+	"time"
 )
 
 func Contains(s []string, e string) bool {
@@ -24,8 +28,11 @@ func ContainsUint(s []uint, e uint) bool {
 }
 
 func RegexFindAllSubmatches(text *string, regex *regexp.Regexp, resultMap *map[string]string) {
-	matches := regex.FindAllStringSubmatch(*text, -1)
+	RegexFillSubmatches(regex.FindAllStringSubmatch(*text, -1), regex, resultMap)
+}
 
+// RegexFillSubmatches fills the map by named groups of matches found before
+func RegexFillSubmatches(matches [][]string, regex *regexp.Regexp, resultMap *map[string]string) {
 	submatches := regex.SubexpNames()
 
 	for _, match := range matches {
@@ -41,6 +48,42 @@ func RegexFindAllSubmatches(text *string, regex *regexp.Regexp, resultMap *map[s
 	}
 }
 
+// RegexFillByteSubmatches fills the map by named groups of matches found in bytes
+func RegexFillByteSubmatches(matches [][][]byte, regex *regexp.Regexp, resultMap *map[string]string) {
+	submatches := regex.SubexpNames()
+
+	for _, match := range matches {
+		for i, name := range submatches {
+			if len(name) > 0 && len(match[i]) > 0 {
+				(*resultMap)[name] = string(match[i])
+			}
+		}
+	}
+}
+
+// CompileRegexes expands named patterns and compiles the list of regexes, nil is returned for an empty list
+func CompileRegexes(expressions []string, patterns map[string]string) ([]*regexp.Regexp, error) {
+	if len(expressions) < 1 {
+		return nil, nil
+	}
+
+	compiled := make([]*regexp.Regexp, 0, len(expressions))
+	for _, expression := range expressions {
+		expression, err := ExpandPatterns(expression, patterns)
+		if err != nil {
+			return nil, err
+		}
+
+		regex, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, regex)
+	}
+
+	return compiled, nil
+}
+
 func RegexSplit(text string, delimeterRegex *regexp.Regexp) []string {
 	indexes := delimeterRegex.FindAllStringIndex(text, -1)
 	laststart := 0
@@ -108,3 +151,18 @@ func CleanSecurityEventsFromDublicates(securityEvents []*SecurityEvent) []*Secur
 	return targetSecurityEvents
 
 }
+
+// NewUuid returns a random (version 4) uuid
+func NewUuid() string {
+	uuid := make([]byte, 16)
+	_, err := rand.Read(uuid)
+	if err != nil {
+		// crypto source is not available, fall back to the time based value
+		binary.BigEndian.PutUint64(uuid, uint64(time.Now().UnixNano()))
+	}
+
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+}